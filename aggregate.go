@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/service-status-go/gtg"
+	log "github.com/Sirupsen/logrus"
+)
+
+const statePath = "/state"
+
+var errNotCollected = errors.New("The checker pods have not been collected yet")
+
+// nodeStatus is the state of a single checker, as served on /state and collected by the aggregator.
+type nodeStatus struct {
+	Node string `json:"node"`
+	releaseState
+}
+
+// nodeStateHandler serves the state of this checker for the aggregator.
+func nodeStateHandler(node string, repo *releaseRepository) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, nodeStatus{Node: node, releaseState: repo.State()})
+	}
+}
+
+// peerDiscoverer finds the base URLs of the checker pods to aggregate.
+type peerDiscoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+type staticPeers []string
+
+func (s staticPeers) Discover(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// srvPeers discovers checker pods through a DNS SRV record, such as the one published for a named port of a
+// headless Kubernetes service.
+type srvPeers struct {
+	name   string
+	lookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func newSRVPeers(name string) *srvPeers {
+	return &srvPeers{name: name, lookup: net.DefaultResolver.LookupSRV}
+}
+
+func (s *srvPeers) Discover(ctx context.Context) ([]string, error) {
+	_, records, err := s.lookup(ctx, "", "", s.name)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		peers = append(peers, "http://"+net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+	}
+	return peers, nil
+}
+
+// kubernetesPeers discovers checker pods from the ready endpoints of a Kubernetes service.
+type kubernetesPeers struct {
+	client    kubernetesClient
+	namespace string
+	service   string
+}
+
+func (k *kubernetesPeers) Discover(ctx context.Context) ([]string, error) {
+	endpoints, err := k.client.Endpoints(ctx, k.namespace, k.service)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Ports) == 0 {
+			continue
+		}
+		port := strconv.Itoa(subset.Ports[0].Port)
+		for _, address := range subset.Addresses {
+			peers = append(peers, "http://"+net.JoinHostPort(address.IP, port))
+		}
+	}
+	return peers, nil
+}
+
+// fleetView summarises the state of all the nodes known to the aggregator.
+type fleetView struct {
+	Generated           time.Time         `json:"generated"`
+	Nodes               int               `json:"nodes"`
+	VersionDistribution map[string]int    `json:"versionDistribution"`
+	NodesBehind         []string          `json:"nodesBehind"`
+	NodesOverdue        []string          `json:"nodesOverdue"`
+	NodesUnknown        []string          `json:"nodesUnknown"`
+	WorstExposure       *fleetExposure    `json:"worstExposure,omitempty"`
+	OldestNode          *fleetNode        `json:"oldestNode,omitempty"`
+	Unreachable         map[string]string `json:"unreachable,omitempty"`
+}
+
+type fleetExposure struct {
+	CVE   string   `json:"cve"`
+	CVSS  float64  `json:"cvss"`
+	Nodes []string `json:"nodes"`
+}
+
+type fleetNode struct {
+	Node    string `json:"node"`
+	Version string `json:"version"`
+}
+
+// aggregator periodically collects the state of every checker pod into a fleet view.
+type aggregator struct {
+	sync.RWMutex
+	discoverer  peerDiscoverer
+	client      *http.Client
+	nodes       map[string]nodeStatus
+	unreachable map[string]string
+	lastCollect time.Time
+	err         error
+	observers   []func(context.Context, map[string]nodeStatus)
+}
+
+func newAggregator(discoverer peerDiscoverer, client *http.Client) *aggregator {
+	return &aggregator{
+		discoverer:  discoverer,
+		client:      client,
+		nodes:       make(map[string]nodeStatus),
+		unreachable: make(map[string]string),
+	}
+}
+
+// Start collects the fleet state every interval, until the context is done.
+func (a *aggregator) Start(ctx context.Context, interval time.Duration) {
+	a.Collect(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Collect(ctx)
+		}
+	}
+}
+
+// Collect discovers the checker pods and fetches the state of each of them concurrently.
+func (a *aggregator) Collect(ctx context.Context) {
+	peers, err := a.discoverer.Discover(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to discover the checker pods.")
+		a.Lock()
+		a.err = err
+		a.Unlock()
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	nodes := make(map[string]nodeStatus)
+	unreachable := make(map[string]string)
+
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			status, err := a.fetch(ctx, peer)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				log.WithError(err).WithField("peer", peer).Warn("Failed to retrieve the state of a checker pod.")
+				unreachable[peer] = err.Error()
+				return
+			}
+			nodes[status.Node] = status
+		}(peer)
+	}
+	wg.Wait()
+
+	a.Lock()
+	a.nodes = nodes
+	a.unreachable = unreachable
+	a.lastCollect = time.Now()
+	a.err = nil
+	observers := a.observers
+	a.Unlock()
+
+	for _, observer := range observers {
+		observer(ctx, nodes)
+	}
+}
+
+// OnCollect registers a function to be called with the node states after every successful collection. It must be
+// called before the aggregator is started.
+func (a *aggregator) OnCollect(observer func(context.Context, map[string]nodeStatus)) {
+	a.Lock()
+	defer a.Unlock()
+	a.observers = append(a.observers, observer)
+}
+
+func (a *aggregator) fetch(ctx context.Context, peer string) (nodeStatus, error) {
+	var status nodeStatus
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(peer, "/")+statePath, nil)
+	if err != nil {
+		return status, err
+	}
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, err
+	}
+	if status.Node == "" {
+		status.Node = peer
+	}
+	return status, nil
+}
+
+// Fleet builds the fleet view from the last collected states.
+func (a *aggregator) Fleet() fleetView {
+	a.RLock()
+	defer a.RUnlock()
+
+	now := time.Now()
+	view := fleetView{
+		Generated:           now,
+		Nodes:               len(a.nodes),
+		VersionDistribution: make(map[string]int),
+		NodesBehind:         []string{},
+		NodesOverdue:        []string{},
+		NodesUnknown:        []string{},
+	}
+	if len(a.unreachable) > 0 {
+		view.Unreachable = a.unreachable
+	}
+
+	exposures := make(map[string]*fleetExposure)
+	for _, name := range a.nodeNames() {
+		node := a.nodes[name]
+		installed := node.InstalledVersion.Version
+
+		// a checker which has not polled successfully yet does not know the versions of its node
+		if node.LastSuccess == nil || installed == "" {
+			view.NodesUnknown = append(view.NodesUnknown, name)
+			continue
+		}
+		view.VersionDistribution[installed]++
+
+		if view.OldestNode == nil || compareVersions(installed, view.OldestNode.Version) < 0 {
+			view.OldestNode = &fleetNode{Node: name, Version: installed}
+		}
+
+		if installed == node.LatestVersion.Version {
+			continue
+		}
+		view.NodesBehind = append(view.NodesBehind, name)
+
+		if deadline, ok := securityDeadline(node.LatestVersion); ok && now.After(deadline) {
+			view.NodesOverdue = append(view.NodesOverdue, name)
+		}
+
+		for _, fix := range node.LatestVersion.SecurityFixes {
+			exposure, ok := exposures[fix.ID]
+			if !ok {
+				exposure = &fleetExposure{CVE: fix.ID, CVSS: fix.CVSS}
+				exposures[fix.ID] = exposure
+			}
+			exposure.Nodes = append(exposure.Nodes, name)
+		}
+	}
+
+	for _, exposure := range exposures {
+		if worst := view.WorstExposure; worst == nil || worseExposure(exposure, worst) {
+			view.WorstExposure = exposure
+		}
+	}
+	return view
+}
+
+// worseExposure orders exposures by CVSS score, then by the number of nodes exposed.
+func worseExposure(a *fleetExposure, b *fleetExposure) bool {
+	if a.CVSS != b.CVSS {
+		return a.CVSS > b.CVSS
+	}
+	if len(a.Nodes) != len(b.Nodes) {
+		return len(a.Nodes) > len(b.Nodes)
+	}
+	return a.CVE < b.CVE
+}
+
+// nodeNames expects the caller to hold the aggregator read lock.
+func (a *aggregator) nodeNames() []string {
+	names := make([]string, 0, len(a.nodes))
+	for name := range a.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FleetHandler serves the fleet view.
+func (a *aggregator) FleetHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, a.Fleet())
+	}
+}
+
+// NodesHandler serves the last collected state of every node.
+func (a *aggregator) NodesHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.RLock()
+		nodes := make([]nodeStatus, 0, len(a.nodes))
+		for _, name := range a.nodeNames() {
+			nodes = append(nodes, a.nodes[name])
+		}
+		a.RUnlock()
+
+		writeJSON(w, http.StatusOK, nodes)
+	}
+}
+
+func (a *aggregator) HealthCheckHandler() func(w http.ResponseWriter, r *http.Request) {
+	hc := fthealth.TimedHealthCheck{
+		HealthCheck: fthealth.HealthCheck{
+			SystemCode:  "coreos-version-checker",
+			Name:        "CoreOS Version Checker Aggregator",
+			Description: "Aggregates the CoreOS versions and security fixes reported by the checker on every node.",
+			Checks:      a.checks(),
+		},
+		Timeout: 10 * time.Second,
+	}
+	return fthealth.Handler(hc)
+}
+
+func (a *aggregator) checks() []fthealth.Check {
+	return []fthealth.Check{
+		{
+			BusinessImpact:   "No business impact.",
+			Name:             "Checker Pods Reachable",
+			PanicGuide:       "https://dewey.ft.com/coreos-version-checker.html",
+			Severity:         2,
+			TechnicalSummary: "The aggregator could not discover or retrieve the state of one or more checker pods, so the fleet view is incomplete.",
+			Checker:          a.checkPeersReachable,
+		},
+		{
+			BusinessImpact:   "It may be possible to compromise our publishing stack using a known security vulnerability.",
+			Name:             "Nodes with Overdue Security Upgrades",
+			PanicGuide:       "https://dewey.ft.com/coreos-version-checker.html",
+			Severity:         1,
+			TechnicalSummary: "One or more nodes have passed the FT policy deadline to upgrade to a CoreOS version with HIGH or CRITICAL security fixes.",
+			Checker:          a.checkNodesOverdue,
+		},
+	}
+}
+
+func (a *aggregator) checkPeersReachable() (string, error) {
+	a.RLock()
+	defer a.RUnlock()
+
+	if a.err != nil {
+		return "", a.err
+	}
+	if a.lastCollect.IsZero() {
+		return "", errNotCollected
+	}
+
+	output := fmt.Sprintf("Collected %d node(s) at %s.", len(a.nodes), a.lastCollect.UTC().Format(releaseDateFormat))
+	if len(a.unreachable) > 0 {
+		peers := make([]string, 0, len(a.unreachable))
+		for peer, err := range a.unreachable {
+			peers = append(peers, peer+": "+err)
+		}
+		sort.Strings(peers)
+		return output, errors.New("Unreachable checker pods: " + strings.Join(peers, "; "))
+	}
+	return output, nil
+}
+
+func (a *aggregator) checkNodesOverdue() (string, error) {
+	view := a.Fleet()
+	output := fmt.Sprintf("%d of %d node(s) are behind the latest version.", len(view.NodesBehind), view.Nodes)
+	if len(view.NodesOverdue) > 0 {
+		return output, errors.New("Nodes past their security upgrade deadline: " + strings.Join(view.NodesOverdue, ", "))
+	}
+	return output, nil
+}
+
+func (a *aggregator) GTG() gtg.Status {
+	a.RLock()
+	defer a.RUnlock()
+
+	if a.lastCollect.IsZero() {
+		return gtg.Status{GoodToGo: false, Message: errNotCollected.Error()}
+	}
+	return gtg.Status{GoodToGo: true}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChecker(node string, state releaseState) *httptest.Server {
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.channel = state.Channel
+	repo.installedVersion = state.InstalledVersion
+	repo.latestVersion = state.LatestVersion
+	if state.LastSuccess != nil {
+		repo.lastSuccess = *state.LastSuccess
+	}
+	return httptest.NewServer(http.HandlerFunc(nodeStateHandler(node, repo)))
+}
+
+func TestAggregatorFleet(t *testing.T) {
+	now := time.Now()
+	node1 := newTestChecker("node-1", newTestState("2135.5.0", "2135.5.0", -1, now))
+	defer node1.Close()
+	node2 := newTestChecker("node-2", newTestState("2135.4.0", "2135.5.0", 7.5, now.Add(-highSecurityFixDeadline*2)))
+	defer node2.Close()
+	state := newTestState("899.17.0", "2135.5.0", 9.8, now)
+	state.LatestVersion.SecurityFixes = []cve{{ID: "CVE-2019-0002", CVSS: 9.8}}
+	node3 := newTestChecker("node-3", state)
+	defer node3.Close()
+	node4 := newTestChecker("node-4", releaseState{Channel: "stable"})
+	defer node4.Close()
+
+	agg := newAggregator(staticPeers{node1.URL, node2.URL, node3.URL, node4.URL, "http://127.0.0.1:1"}, &http.Client{Timeout: time.Second})
+	assert.False(t, agg.GTG().GoodToGo)
+
+	agg.Collect(context.Background())
+	assert.True(t, agg.GTG().GoodToGo)
+
+	view := agg.Fleet()
+	assert.Equal(t, 4, view.Nodes)
+	assert.Equal(t, map[string]int{"2135.5.0": 1, "2135.4.0": 1, "899.17.0": 1}, view.VersionDistribution, "the node which has not polled yet is left out")
+	assert.Equal(t, []string{"node-4"}, view.NodesUnknown)
+	assert.Equal(t, []string{"node-2", "node-3"}, view.NodesBehind)
+	assert.Equal(t, []string{"node-2"}, view.NodesOverdue)
+	assert.Equal(t, &fleetNode{Node: "node-3", Version: "899.17.0"}, view.OldestNode)
+	assert.Equal(t, "CVE-2019-0002", view.WorstExposure.CVE)
+	assert.Equal(t, 9.8, view.WorstExposure.CVSS)
+	assert.Equal(t, []string{"node-3"}, view.WorstExposure.Nodes)
+	assert.Contains(t, view.Unreachable, "http://127.0.0.1:1")
+
+	_, err := agg.checkPeersReachable()
+	assert.Error(t, err)
+
+	_, err = agg.checkNodesOverdue()
+	assert.EqualError(t, err, "Nodes past their security upgrade deadline: node-2")
+}
+
+func TestSRVPeers(t *testing.T) {
+	s := newSRVPeers("_http._tcp.coreos-version-checker")
+	s.lookup = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_http._tcp.coreos-version-checker", name)
+		return "", []*net.SRV{{Target: "10-2-3-4.coreos-version-checker.", Port: 8080}}, nil
+	}
+
+	peers, err := s.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://10-2-3-4.coreos-version-checker:8080"}, peers)
+}
+
+func TestKubernetesPeers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/default/endpoints/coreos-version-checker", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"subsets": [{"addresses": [{"ip": "10.2.3.4"}, {"ip": "10.2.3.5"}], "ports": [{"port": 8080}]}]}`))
+	}))
+	defer server.Close()
+
+	client := &kubeRESTClient{client: server.Client(), host: server.URL, token: "token", namespace: "default"}
+	k := &kubernetesPeers{client: client, service: "coreos-version-checker"}
+
+	peers, err := k.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://10.2.3.4:8080", "http://10.2.3.5:8080"}, peers)
+}
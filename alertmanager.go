@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+const alertmanagerAlertsPath = "/api/v2/alerts"
+
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertmanagerPusher pushes the upgrade status of the node to a Prometheus Alertmanager after every poll. Firing
+// alerts are re-sent on every poll with endsAt pushed forward by the alert TTL, so they resolve themselves if the
+// checker stops running; alerts which are no longer firing are explicitly resolved by sending endsAt as now.
+type alertmanagerPusher struct {
+	sync.Mutex
+	client   *retryablehttp.Client
+	url      string
+	node     string
+	alertTTL time.Duration
+	firing   map[string]alertmanagerAlert
+	// stateFile keeps the firing alerts across restarts, if set
+	stateFile string
+	now       func() time.Time
+}
+
+func newAlertmanagerPusher(client *retryablehttp.Client, alertmanagerURL string, node string, alertTTL time.Duration) *alertmanagerPusher {
+	return &alertmanagerPusher{
+		client:   client,
+		url:      strings.TrimSuffix(alertmanagerURL, "/") + alertmanagerAlertsPath,
+		node:     node,
+		alertTTL: alertTTL,
+		firing:   make(map[string]alertmanagerAlert),
+		now:      time.Now,
+	}
+}
+
+// PersistTo keeps the firing alerts in the file, loading those fired before a restart. The upgrade reboots the node,
+// so without it the alerts of the previous version are never resolved and fire until they expire.
+func (a *alertmanagerPusher) PersistTo(path string) error {
+	a.Lock()
+	defer a.Unlock()
+	a.stateFile = path
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var firing map[string]alertmanagerAlert
+	if err := json.Unmarshal(content, &firing); err != nil {
+		return err
+	}
+	if firing != nil {
+		a.firing = firing
+	}
+	return nil
+}
+
+// save expects the caller to hold the lock.
+func (a *alertmanagerPusher) save() {
+	if a.stateFile == "" {
+		return
+	}
+
+	content, err := json.Marshal(a.firing)
+	if err == nil {
+		// written to a temporary file first, so a crash mid-write does not lose the state
+		tmp := a.stateFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, content, 0644); err == nil {
+			err = os.Rename(tmp, a.stateFile)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", a.stateFile).Error("Failed to save the firing alerts.")
+	}
+}
+
+// Observe is registered with the refresher to be called after every poll.
+func (a *alertmanagerPusher) Observe(ctx context.Context, state releaseState) {
+	if state.LastSuccess == nil {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	now := a.now()
+	current := make(map[string]alertmanagerAlert)
+	for _, alert := range a.alerts(state, now) {
+		key := fingerprint(alert.Labels)
+		if previous, ok := a.firing[key]; ok {
+			alert.StartsAt = previous.StartsAt
+		}
+		current[key] = alert
+	}
+
+	alerts := make([]alertmanagerAlert, 0, len(current)+len(a.firing))
+	for _, alert := range current {
+		alerts = append(alerts, alert)
+	}
+
+	resolved := make(map[string]alertmanagerAlert)
+	for key, alert := range a.firing {
+		if _, ok := current[key]; !ok {
+			alert.EndsAt = now
+			resolved[key] = alert
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(alerts) == 0 {
+		return
+	}
+
+	if err := PostJSON(ctx, a.client, a.url, alerts); err != nil {
+		log.WithError(err).Error("Failed to push alerts to Alertmanager.")
+		// keep the resolved alerts around so they are resolved again on the next poll
+		for key, alert := range resolved {
+			current[key] = alert
+		}
+	}
+	a.firing = current
+	a.save()
+}
+
+func (a *alertmanagerPusher) alerts(state releaseState, now time.Time) []alertmanagerAlert {
+	installed := state.InstalledVersion
+	latest := state.LatestVersion
+	if installed.Version == latest.Version {
+		return nil
+	}
+
+	band := severityBand(latest.MaxCVSS)
+	labels := map[string]string{
+		"alertname":         "CoreOSUpgradeAvailable",
+		"node":              a.node,
+		"channel":           state.Channel,
+		"installed_version": installed.Version,
+		"latest_version":    latest.Version,
+		"severity":          band,
+	}
+	if state.Board != "" {
+		labels["board"] = state.Board
+	}
+	annotations := map[string]string{
+		"summary":     "A new version of CoreOS is available on " + a.node + ": " + latest.Version,
+		"description": securityFixesOutput(state, 0, 0),
+	}
+
+	endsAt := now.Add(a.alertTTL)
+	alerts := []alertmanagerAlert{{Labels: labels, Annotations: annotations, StartsAt: now, EndsAt: endsAt}}
+
+	if deadline, ok := securityDeadline(latest); ok && now.After(deadline) {
+		overdue := make(map[string]string)
+		for k, v := range labels {
+			overdue[k] = v
+		}
+		overdue["alertname"] = "CoreOSSecurityUpgradeOverdue"
+		alerts = append(alerts, alertmanagerAlert{
+			Labels: overdue,
+			Annotations: map[string]string{
+				"summary":     "The upgrade deadline for the " + band + " security fixes in CoreOS " + latest.Version + " has passed on " + a.node,
+				"description": annotations["description"],
+				"deadline":    deadline.UTC().Format(time.RFC3339),
+			},
+			StartsAt: now,
+			EndsAt:   endsAt,
+		})
+	}
+	return alerts
+}
+
+// severityBand maps a CVSS score onto the NVD severity ratings.
+func severityBand(maxCVSS *float64) string {
+	switch {
+	case maxCVSS == nil || *maxCVSS <= 0:
+		return "none"
+	case *maxCVSS >= 9:
+		return "critical"
+	case *maxCVSS >= 7:
+		return "high"
+	case *maxCVSS >= 4:
+		return "medium"
+	}
+	return "low"
+}
+
+func fingerprint(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertmanagerPusher(t *testing.T) {
+	var pushes [][]alertmanagerAlert
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, alertmanagerAlertsPath, r.URL.Path)
+		var alerts []alertmanagerAlert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alerts))
+		pushes = append(pushes, alerts)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	client := newRetryableClient(&http.Client{})
+	client.RetryMax = 0
+	a := newAlertmanagerPusher(client, server.URL+"/", "node-1", time.Hour)
+	a.now = func() time.Time { return now }
+
+	a.Observe(context.Background(), newTestState("2135.4.0", "2135.4.0", -1, now))
+	assert.Empty(t, pushes)
+
+	released := now.Add(-time.Hour * 72)
+	a.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 1)
+	assert.Len(t, pushes[0], 2)
+	for _, alert := range pushes[0] {
+		assert.Equal(t, "node-1", alert.Labels["node"])
+		assert.Equal(t, "stable", alert.Labels["channel"])
+		assert.Equal(t, "2135.4.0", alert.Labels["installed_version"])
+		assert.Equal(t, "2135.5.0", alert.Labels["latest_version"])
+		assert.Equal(t, "critical", alert.Labels["severity"])
+		assert.Equal(t, now.Add(time.Hour), alert.EndsAt)
+	}
+
+	now = now.Add(time.Minute * 30)
+	a.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 2)
+	assert.Equal(t, now.Add(-time.Minute*30), pushes[1][0].StartsAt)
+	assert.Equal(t, now.Add(time.Hour), pushes[1][0].EndsAt)
+
+	status = http.StatusBadRequest
+	now = now.Add(time.Minute * 30)
+	a.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 3)
+	assert.Len(t, pushes[2], 2)
+
+	status = http.StatusOK
+	a.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 4)
+	for _, alert := range pushes[3] {
+		assert.Equal(t, now, alert.EndsAt)
+	}
+
+	a.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 4)
+}
+
+func TestAlertmanagerPusherResolvesAfterRestart(t *testing.T) {
+	var pushes [][]alertmanagerAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alerts []alertmanagerAlert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alerts))
+		pushes = append(pushes, alerts)
+	}))
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "alertmanager")
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "alertmanager.json")
+
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	released := now.Add(-time.Hour * 72)
+	a := newAlertmanagerPusher(newRetryableClient(&http.Client{}), server.URL, "node-1", time.Hour)
+	a.now = func() time.Time { return now }
+	assert.NoError(t, a.PersistTo(stateFile))
+	a.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 1)
+
+	// the upgrade reboots the node, which restarts the checker
+	restarted := newAlertmanagerPusher(newRetryableClient(&http.Client{}), server.URL, "node-1", time.Hour)
+	restarted.now = func() time.Time { return now }
+	assert.NoError(t, restarted.PersistTo(stateFile))
+	restarted.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", 9.8, released))
+	if assert.Len(t, pushes, 2) && assert.Len(t, pushes[1], 2) {
+		for _, alert := range pushes[1] {
+			assert.Equal(t, "2135.4.0", alert.Labels["installed_version"])
+			assert.Equal(t, now, alert.EndsAt, "the alerts fired before the restart are resolved")
+		}
+	}
+
+	restarted.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", 9.8, released))
+	assert.Len(t, pushes, 2)
+}
+
+func TestSeverityBand(t *testing.T) {
+	for score, band := range map[float64]string{-1: "none", 0: "none", 2.1: "low", 5: "medium", 7: "high", 9.3: "critical"} {
+		s := score
+		assert.Equal(t, band, severityBand(&s))
+	}
+	assert.Equal(t, "none", severityBand(nil))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	cli "github.com/jawher/mow.cli"
+)
+
+const (
+	bundleManifestName  = "manifest.json"
+	bundleSignatureName = "manifest.json.sig"
+)
+
+// releaseSource retrieves the JSON documents of the release feeds and the CVE API by URI, which the caller decodes
+// and closes.
+type releaseSource interface {
+	Open(ctx context.Context, uri string) (io.ReadCloser, error)
+}
+
+// httpReleaseSource retrieves the documents from coreos.com and cve.circl.lu.
+type httpReleaseSource struct {
+	client *retryablehttp.Client
+}
+
+func (s httpReleaseSource) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return Open(ctx, s.client, uri)
+}
+
+func (s httpReleaseSource) OpenIfModified(ctx context.Context, uri string, validators feedValidators) (io.ReadCloser, feedValidators, error) {
+	return OpenIfModified(ctx, s.client, uri, validators)
+}
+
+// conditionalSource is implemented by the sources which can skip retrieving a release feed that has not changed.
+type conditionalSource interface {
+	OpenIfModified(ctx context.Context, uri string, validators feedValidators) (io.ReadCloser, feedValidators, error)
+}
+
+// offlineBundle serves the documents snapshotted by the export command, for air-gapped environments. The documents
+// are looked up by the URI they were retrieved from, so the bundle must be exported with the same sources as the
+// checker is configured with.
+type offlineBundle struct {
+	created   time.Time
+	documents map[string][]byte
+}
+
+// bundleManifest lists the documents in the bundle with their checksums. The manifest is signed with Ed25519, which
+// covers the documents through their checksums, so the checkers only hold the public key.
+type bundleManifest struct {
+	Created   time.Time                 `json:"created"`
+	Documents map[string]bundleDocument `json:"documents"`
+}
+
+type bundleDocument struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+func (b *offlineBundle) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	document, ok := b.documents[uri]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the offline bundle", uri)
+	}
+	return ioutil.NopCloser(bytes.NewReader(document)), nil
+}
+
+// parseBundlePrivateKey decodes the base64 private key the bundle is signed with, either the 32 byte seed, e.g. from
+// `openssl rand -base64 32`, or the 64 byte private key.
+func parseBundlePrivateKey(key string) (ed25519.PrivateKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("The offline bundle private key is not base64: %v", err)
+	}
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(decoded), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(decoded), nil
+	}
+	return nil, fmt.Errorf("The offline bundle private key must be %d or %d bytes, not %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(decoded))
+}
+
+// parseBundlePublicKey decodes the base64 public key the bundle is verified with.
+func parseBundlePublicKey(key string) (ed25519.PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("The offline bundle public key is not base64: %v", err)
+	}
+	if len(decoded) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("The offline bundle public key must be %d bytes, not %d", ed25519.PublicKeySize, len(decoded))
+	}
+	return ed25519.PublicKey(decoded), nil
+}
+
+// writeOfflineBundle writes the documents as a gzipped tar archive, with the manifest and its signature.
+func writeOfflineBundle(w io.Writer, key ed25519.PrivateKey, created time.Time, documents map[string][]byte) error {
+	uris := make([]string, 0, len(documents))
+	for uri := range documents {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	manifest := bundleManifest{Created: created.UTC(), Documents: make(map[string]bundleDocument)}
+	files := make(map[string][]byte)
+	for i, uri := range uris {
+		path := fmt.Sprintf("documents/%05d.json", i)
+		checksum := sha256.Sum256(documents[uri])
+		manifest.Documents[uri] = bundleDocument{Path: path, SHA256: hex.EncodeToString(checksum[:])}
+		files[path] = documents[uri]
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files[bundleManifestName] = manifestJSON
+	files[bundleSignatureName] = []byte("ed25519=" + base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestJSON)))
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: manifest.Created}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readOfflineBundle reads the bundle, and verifies the signature of the manifest and the checksums of the documents.
+func readOfflineBundle(path string, key ed25519.PublicKey) (*offlineBundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = content
+	}
+
+	manifestJSON, ok := files[bundleManifestName]
+	if !ok {
+		return nil, errors.New("The offline bundle has no manifest")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(files[bundleSignatureName]), "ed25519="))
+	if err != nil || !ed25519.Verify(key, manifestJSON, signature) {
+		return nil, errors.New("The signature of the offline bundle does not match")
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, err
+	}
+
+	bundle := &offlineBundle{created: manifest.Created, documents: make(map[string][]byte)}
+	for uri, document := range manifest.Documents {
+		content, ok := files[document.Path]
+		if !ok {
+			return nil, fmt.Errorf("The offline bundle is missing %s", document.Path)
+		}
+		checksum := sha256.Sum256(content)
+		if hex.EncodeToString(checksum[:]) != document.SHA256 {
+			return nil, fmt.Errorf("The checksum of %s in the offline bundle does not match", document.Path)
+		}
+		bundle.documents[uri] = content
+	}
+	return bundle, nil
+}
+
+// exportDocuments retrieves the release feeds, and the CVEs referenced by the release notes of every release from
+// minVersion onwards. The documents are kept as they were retrieved, once they have been checked to be in the
+// expected format.
+func exportDocuments(ctx context.Context, source releaseSource, sources releaseSources, extraFeeds []string, minVersion string) (map[string][]byte, error) {
+	documents := make(map[string][]byte)
+	cveIDs := make(map[string]struct{})
+
+	feeds := append([]string{sources.All, sources.Alpha, sources.Beta, sources.Stable}, extraFeeds...)
+	for _, uri := range feeds {
+		if _, ok := documents[uri]; ok {
+			continue
+		}
+
+		document, err := readDocument(ctx, source, uri)
+		if err != nil {
+			return nil, err
+		}
+		releases, err := decodeReleaseFeed(bytes.NewReader(document), func(version string) bool {
+			return minVersion == "" || compareVersions(version, minVersion) >= 0
+		})
+		if err != nil {
+			return nil, schemaError{uri: uri, err: err}
+		}
+		documents[uri] = document
+
+		for _, release := range releases {
+			for _, id := range parseReleaseNotes(release.ReleaseNotes) {
+				cveIDs[id] = struct{}{}
+			}
+		}
+	}
+
+	for id := range cveIDs {
+		uri := fmt.Sprintf(sources.CVE, id)
+		document, err := readDocument(ctx, source, uri)
+		if err != nil {
+			return nil, err
+		}
+		var cve cveDocument
+		if err := json.Unmarshal(document, &cve); err != nil {
+			return nil, schemaError{uri: uri, err: err}
+		}
+		documents[uri] = document
+	}
+	return documents, nil
+}
+
+func readDocument(ctx context.Context, source releaseSource, uri string) ([]byte, error) {
+	body, err := source.Open(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve %s: %v", uri, err)
+	}
+	defer body.Close()
+
+	document, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve %s: %v", uri, err)
+	}
+	return document, nil
+}
+
+func exportCommand(cmd *cli.Cmd) {
+	cmd.Spec = "[--min-version] [--key] FILE"
+
+	minVersion := cmd.String(cli.StringOpt{
+		Name: "min-version",
+		Desc: "Only snapshot the CVEs of releases from this version onwards, e.g. 1967.0.0. By default the CVEs of every release are included.",
+	})
+	key := cmd.String(cli.StringOpt{
+		Name:   "key",
+		Desc:   "The base64 Ed25519 private key, or its 32 byte seed, used to sign the bundle.",
+		EnvVar: "OFFLINE_BUNDLE_PRIVATE_KEY",
+	})
+	file := cmd.String(cli.StringArg{
+		Name: "FILE",
+		Desc: "The bundle to write, e.g. releases.tar.gz",
+	})
+
+	cmd.Action = func() {
+		if *key == "" {
+			log.Fatal("The --key to sign the bundle with must be provided.")
+		}
+		privateKey, err := parseBundlePrivateKey(*key)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to read the --key.")
+		}
+
+		cfg, err := loadCheckerConfig()
+		if err != nil {
+			log.WithError(err).Fatal("Failed to load the config.")
+		}
+
+		var extraFeeds []string
+		for _, mapping := range cfg.Groups {
+			if mapping.Source != "" {
+				extraFeeds = append(extraFeeds, mapping.Source)
+			}
+		}
+
+		source := httpReleaseSource{client: cfg.HTTP.retryableClient()}
+		documents, err := exportDocuments(context.Background(), source, cfg.Sources, extraFeeds, *minVersion)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to snapshot the releases.")
+		}
+
+		var buf bytes.Buffer
+		if err := writeOfflineBundle(&buf, privateKey, time.Now(), documents); err != nil {
+			log.WithError(err).Fatal("Failed to write the offline bundle.")
+		}
+		if err := ioutil.WriteFile(*file, buf.Bytes(), 0644); err != nil {
+			log.WithError(err).Fatal("Failed to write the offline bundle.")
+		}
+		publicKey := base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+		log.WithField("bundle", *file).WithField("documents", len(documents)).WithField("publicKey", publicKey).Info("Exported the offline bundle, verify it with the public key.")
+	}
+}
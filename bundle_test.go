@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeReleaseSource map[string]string
+
+func (s fakeReleaseSource) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	document, ok := s[uri]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return ioutil.NopCloser(strings.NewReader(document)), nil
+}
+
+func writeTestBundle(t *testing.T, key ed25519.PrivateKey, documents map[string][]byte) string {
+	var buf bytes.Buffer
+	assert.NoError(t, writeOfflineBundle(&buf, key, time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC), documents))
+
+	f, _ := ioutil.TempFile("", "bundle")
+	f.Write(buf.Bytes())
+	f.Close()
+	return f.Name()
+}
+
+func TestExportAndServeOfflineBundle(t *testing.T) {
+	source := fakeReleaseSource{
+		allReleasesURI:    `{"2135.4.0": {"version": "2135.4.0", "release_notes": "Fix CVE-2019-0001"}, "1855.4.0": {"version": "1855.4.0", "release_notes": "Fix CVE-2018-0001"}}`,
+		alphaReleasesURI:  `{}`,
+		betaReleasesURI:   `{}`,
+		stableReleasesURI: coreosReleaseHTTPResponse,
+		"http://cve.circl.lu/api/cve/CVE-2019-0001": `{"cvss": "9.8"}`,
+	}
+
+	documents, err := exportDocuments(context.Background(), source, defaultReleaseSources(), nil, "2000.0.0")
+	assert.NoError(t, err)
+	assert.Len(t, documents, 5, "the CVE of the release before the min version is left out")
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	path := writeTestBundle(t, privateKey, documents)
+	defer os.Remove(path)
+
+	bundle, err := readOfflineBundle(path, publicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC), bundle.created)
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.UseOfflineBundle(bundle)
+	repo.channel = "stable"
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.Equal(t, "2135.4.0", repo.latestVersion.Version)
+
+	assert.Equal(t, cve{ID: "CVE-2019-0001", CVSS: 9.8}, retrieveCVE(context.Background(), bundle, cveURI, "CVE-2019-0001"))
+	assert.EqualError(t, retrieveCVE(context.Background(), bundle, cveURI, "CVE-2018-0001").err, "http://cve.circl.lu/api/cve/CVE-2018-0001 is not in the offline bundle")
+}
+
+func TestExportFailsOnUnavailableSource(t *testing.T) {
+	_, err := exportDocuments(context.Background(), fakeReleaseSource{}, defaultReleaseSources(), nil, "")
+	assert.EqualError(t, err, "Failed to retrieve https://coreos.com/releases/releases.json: not found")
+}
+
+func TestOfflineBundleSignature(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	path := writeTestBundle(t, privateKey, map[string][]byte{stableReleasesURI: []byte(coreosReleaseHTTPResponse)})
+	defer os.Remove(path)
+
+	anotherKey, _, _ := ed25519.GenerateKey(nil)
+	_, err := readOfflineBundle(path, anotherKey)
+	assert.EqualError(t, err, "The signature of the offline bundle does not match")
+
+	_, err = readOfflineBundle(path, privateKey.Public().(ed25519.PublicKey))
+	assert.NoError(t, err)
+}
+
+func TestParseBundleKeys(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	privateKey, err := parseBundlePrivateKey(seed)
+	assert.NoError(t, err)
+
+	publicKey, err := parseBundlePublicKey(base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey)))
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey.Public(), publicKey)
+
+	fromPrivateKey, err := parseBundlePrivateKey(base64.StdEncoding.EncodeToString(privateKey))
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, fromPrivateKey)
+
+	_, err = parseBundlePublicKey(seed[:8])
+	assert.EqualError(t, err, "The offline bundle public key must be 32 bytes, not 6")
+	_, err = parseBundlePrivateKey("secret")
+	assert.Error(t, err, "a shared secret is not a key")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	log "github.com/Sirupsen/logrus"
+	cli "github.com/jawher/mow.cli"
+)
+
+// The exit codes of the check command follow the Nagios plugin convention, ordered so the highest, worst, outcome
+// wins.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatusNames = map[int]string{
+	checkOK:       "ok",
+	checkWarning:  "warning",
+	checkCritical: "critical",
+	checkUnknown:  "unknown",
+}
+
+type checkResult struct {
+	Name     string `json:"name"`
+	Severity uint8  `json:"severity"`
+	OK       bool   `json:"ok"`
+	Output   string `json:"output"`
+}
+
+type checkReport struct {
+	Node     string        `json:"node,omitempty"`
+	Status   string        `json:"status"`
+	ExitCode int           `json:"exitCode"`
+	State    releaseState  `json:"state"`
+	Checks   []checkResult `json:"checks"`
+}
+
+func checkCommand(cmd *cli.Cmd) {
+	output := cmd.String(cli.StringOpt{
+		Name:   "output o",
+		Value:  "text",
+		Desc:   "The output format, text or json.",
+		EnvVar: "CHECK_OUTPUT",
+	})
+
+	cmd.Action = func() {
+		if *output != "text" && *output != "json" {
+			fmt.Fprintf(os.Stderr, "Unknown output format %s, expected text or json.\n", *output)
+			cli.Exit(checkUnknown)
+		}
+
+		// the logs go to stderr, out of the way of the report
+		log.SetLevel(log.WarnLevel)
+
+		cfg, err := loadCheckerConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load the config: %v\n", err)
+			cli.Exit(checkUnknown)
+		}
+
+		repo, err := newCheckerRepository(&http.Client{Timeout: time.Duration(cfg.HTTP.Timeout)}, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load the offline bundle: %v\n", err)
+			cli.Exit(checkUnknown)
+		}
+		setSecurityPolicy(cfg.Policy)
+		repo.UpdateError(pollCoreOSReleases(context.Background(), repo))
+
+		report := runChecks(*nodeName, repo, NewHealthService(repo, time.Duration(cfg.MaxDataAge)).checks())
+		if err := writeCheckReport(os.Stdout, report, *output); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the report: %v\n", err)
+			cli.Exit(checkUnknown)
+		}
+		cli.Exit(report.ExitCode)
+	}
+}
+
+// runChecks evaluates the health checks once. A failed poll makes the outcome unknown, as the other checks have
+// nothing to go on; otherwise a failing severity 1 check is critical and any other failing check is a warning.
+func runChecks(node string, repo *releaseRepository, checks []fthealth.Check) checkReport {
+	report := checkReport{Node: node, State: repo.State(), ExitCode: checkOK}
+	if report.State.Error != "" {
+		report.ExitCode = checkUnknown
+	}
+
+	for _, check := range checks {
+		output, err := check.Checker()
+		result := checkResult{Name: check.Name, Severity: check.Severity, OK: err == nil, Output: output}
+		if err != nil {
+			result.Output = err.Error()
+			code := checkWarning
+			if check.Severity == 1 {
+				code = checkCritical
+			}
+			if code > report.ExitCode {
+				report.ExitCode = code
+			}
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	report.Status = checkStatusNames[report.ExitCode]
+	return report
+}
+
+func writeCheckReport(w io.Writer, report checkReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	state := report.State
+	if _, err := fmt.Fprintf(w, "CoreOS %s on the %s channel: %s\n", state.InstalledVersion.Version, state.channelDescription(), report.Status); err != nil {
+		return err
+	}
+	for _, result := range report.Checks {
+		status := "OK  "
+		if !result.OK {
+			status = "FAIL"
+		}
+		if _, err := fmt.Fprintf(w, "%s [severity %d] %s: %s\n", status, result.Severity, result.Name, result.Output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunChecksExitCodes(t *testing.T) {
+	maxCVSS := -1.0
+	repo := newTestHealthRepo("2135.5.0", coreOSRelease{Version: "2135.5.0", MaxCVSS: &maxCVSS})
+	repo.group, repo.groupMapped = "stable", true
+	service := NewHealthService(repo, time.Hour)
+
+	report := runChecks("node-1", repo, service.checks())
+	assert.Equal(t, checkOK, report.ExitCode)
+	assert.Equal(t, "ok", report.Status)
+	assert.Len(t, report.Checks, len(service.checks()))
+
+	repo.latestVersion = coreOSRelease{Version: "2135.6.0", MaxCVSS: &maxCVSS}
+	report = runChecks("node-1", repo, service.checks())
+	assert.Equal(t, checkWarning, report.ExitCode, "a new version without security fixes is a warning")
+
+	released := time.Now().Add(-time.Hour * 72)
+	maxCVSS = 9.8
+	repo.latestVersion = coreOSRelease{Version: "2135.6.0", MaxCVSS: &maxCVSS, ReleaseDate: &released, SecurityFixes: []cve{{ID: "CVE-2019-0001", CVSS: 9.8}}}
+	report = runChecks("node-1", repo, service.checks())
+	assert.Equal(t, checkCritical, report.ExitCode)
+	assert.Equal(t, "critical", report.Status)
+
+	repo.err = errors.New("Release not found")
+	report = runChecks("node-1", repo, service.checks())
+	assert.Equal(t, checkUnknown, report.ExitCode, "a failed poll wins over the other checks")
+}
+
+func TestWriteCheckReport(t *testing.T) {
+	maxCVSS := -1.0
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{Version: "2135.5.0", MaxCVSS: &maxCVSS})
+	repo.board = "amd64-usr"
+	report := runChecks("node-1", repo, []fthealth.Check{{Name: "New CoreOS Version", Severity: 2, Checker: compareInstalledWithLatest(repo)}})
+
+	var text bytes.Buffer
+	assert.NoError(t, writeCheckReport(&text, report, "text"))
+	assert.Equal(t, "CoreOS 2135.4.0 on the stable amd64-usr channel: warning\n"+
+		"FAIL [severity 2] New CoreOS Version: There is a new version of CoreOS available: 2135.5.0\n", text.String())
+
+	var out bytes.Buffer
+	assert.NoError(t, writeCheckReport(&out, report, "json"))
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, "warning", decoded["status"])
+	assert.Equal(t, float64(checkWarning), decoded["exitCode"])
+	assert.Equal(t, "node-1", decoded["node"])
+	assert.Equal(t, "amd64-usr", decoded["state"].(map[string]interface{})["board"])
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configWatchInterval is how often the config file is checked for changes.
+const configWatchInterval = 10 * time.Second
+
+// config holds the settings of the checker. It is built from the defaults, overridden by the config file, then by
+// the environment variables, then by the command line options.
+type config struct {
+	Port               int            `yaml:"port"`
+	UpdateConf         string         `yaml:"updateConf"`
+	UpdateConfDefaults string         `yaml:"updateConfDefaults"`
+	ReleaseConf        string         `yaml:"releaseConf"`
+	OSRelease          string         `yaml:"osRelease"`
+	PollInterval       duration       `yaml:"pollInterval"`
+	PollTimeout        duration       `yaml:"pollTimeout"`
+	CVEWorkers         int            `yaml:"cveWorkers"`
+	RefreshMinInterval duration       `yaml:"refreshMinInterval"`
+	MaxDataAge         duration       `yaml:"maxDataAge"`
+	BundleMaxAge       duration       `yaml:"bundleMaxAge"`
+	DrainTimeout       duration       `yaml:"drainTimeout"`
+	HTTP               httpConfig     `yaml:"http"`
+	Sources            releaseSources `yaml:"sources"`
+	Groups             groupMappings  `yaml:"groups"`
+	Policy             securityPolicy `yaml:"policy"`
+}
+
+type httpConfig struct {
+	Timeout      duration `yaml:"timeout"`
+	RetryMax     int      `yaml:"retryMax"`
+	RetryWaitMin duration `yaml:"retryWaitMin"`
+	RetryWaitMax duration `yaml:"retryWaitMax"`
+}
+
+// releaseSources are the locations of the CoreOS release feeds and the CVE API.
+type releaseSources struct {
+	CVE    string `yaml:"cve"`
+	All    string `yaml:"all"`
+	Alpha  string `yaml:"alpha"`
+	Beta   string `yaml:"beta"`
+	Stable string `yaml:"stable"`
+}
+
+// channel returns the release feed of the channel.
+func (s releaseSources) channel(name string) (string, error) {
+	switch name {
+	case "alpha":
+		return s.Alpha, nil
+	case "beta":
+		return s.Beta, nil
+	case "stable":
+		return s.Stable, nil
+	}
+	return "", errors.New("Unknown channel")
+}
+
+// groupMapping maps a CoreUpdate group, such as coreUpdateChan1, onto the channel whose releases it follows. The group
+// is matched by name, or by a regular expression against the whole group, and the releases may come from a source
+// other than the channel feed.
+type groupMapping struct {
+	Group   string `yaml:"group"`
+	Pattern string `yaml:"pattern"`
+	Channel string `yaml:"channel"`
+	Source  string `yaml:"source"`
+}
+
+type groupMappings []groupMapping
+
+// resolve returns the channel and optional release source of the group. The standard channels always map onto
+// themselves, otherwise the first matching mapping wins.
+func (m groupMappings) resolve(group string) (channel string, source string, mapped bool) {
+	switch group {
+	case "alpha", "beta", "stable":
+		return group, "", true
+	}
+
+	for _, mapping := range m {
+		if mapping.Group == group {
+			return mapping.Channel, mapping.Source, true
+		}
+		if mapping.Pattern != "" {
+			if ok, _ := regexp.MatchString("^(?:"+mapping.Pattern+")$", group); ok {
+				return mapping.Channel, mapping.Source, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func (m groupMappings) validate() []string {
+	var problems []string
+	for i, mapping := range m {
+		if (mapping.Group == "") == (mapping.Pattern == "") {
+			problems = append(problems, fmt.Sprintf("groups[%d] must have exactly one of group or pattern", i))
+		}
+		if _, err := regexp.Compile(mapping.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("groups[%d] has an invalid pattern: %v", i, err))
+		}
+		switch mapping.Channel {
+		case "alpha", "beta", "stable":
+		default:
+			problems = append(problems, fmt.Sprintf("groups[%d] channel must be alpha, beta or stable", i))
+		}
+	}
+	return problems
+}
+
+func defaultConfig() config {
+	return config{
+		Port:               8080,
+		UpdateConf:         "/etc/coreos/update.conf",
+		UpdateConfDefaults: defaultUpdateConfDefaultsPath,
+		ReleaseConf:        "/usr/share/coreos/release",
+		OSRelease:          defaultOSReleasePath,
+		PollInterval:       duration(time.Minute * 30),
+		PollTimeout:        duration(time.Minute * 2),
+		CVEWorkers:         8,
+		RefreshMinInterval: duration(time.Minute),
+		MaxDataAge:         duration(time.Hour * 2),
+		BundleMaxAge:       duration(time.Hour * 24 * 7),
+		DrainTimeout:       duration(time.Second * 10),
+		HTTP: httpConfig{
+			Timeout:      duration(1500 * time.Millisecond),
+			RetryMax:     5,
+			RetryWaitMin: duration(100 * time.Millisecond),
+			RetryWaitMax: duration(2 * time.Second),
+		},
+		Sources: defaultReleaseSources(),
+		Policy:  defaultSecurityPolicy(),
+	}
+}
+
+func defaultReleaseSources() releaseSources {
+	return releaseSources{
+		CVE:    cveURI,
+		All:    allReleasesURI,
+		Alpha:  alphaReleasesURI,
+		Beta:   betaReleasesURI,
+		Stable: stableReleasesURI,
+	}
+}
+
+// envOverride points an environment variable at the setting it overrides.
+type envOverride struct {
+	name  string
+	value interface{}
+}
+
+// envOverrides lists the environment variables which override the settings in the config file.
+func (c *config) envOverrides() []envOverride {
+	return []envOverride{
+		{"PORT", &c.Port},
+		{"UPDATE_CONF", &c.UpdateConf},
+		{"UPDATE_CONF_DEFAULTS", &c.UpdateConfDefaults},
+		{"RELEASE_CONF", &c.ReleaseConf},
+		{"OS_RELEASE", &c.OSRelease},
+		{"POLL_INTERVAL", &c.PollInterval},
+		{"POLL_TIMEOUT", &c.PollTimeout},
+		{"CVE_WORKERS", &c.CVEWorkers},
+		{"REFRESH_MIN_INTERVAL", &c.RefreshMinInterval},
+		{"MAX_DATA_AGE", &c.MaxDataAge},
+		{"BUNDLE_MAX_AGE", &c.BundleMaxAge},
+		{"DRAIN_TIMEOUT", &c.DrainTimeout},
+		{"HTTP_TIMEOUT", &c.HTTP.Timeout},
+		{"HTTP_RETRY_MAX", &c.HTTP.RetryMax},
+		{"HTTP_RETRY_WAIT_MIN", &c.HTTP.RetryWaitMin},
+		{"HTTP_RETRY_WAIT_MAX", &c.HTTP.RetryWaitMax},
+		{"CVE_URI", &c.Sources.CVE},
+		{"RELEASES_URI", &c.Sources.All},
+		{"ALPHA_RELEASES_URI", &c.Sources.Alpha},
+		{"BETA_RELEASES_URI", &c.Sources.Beta},
+		{"STABLE_RELEASES_URI", &c.Sources.Stable},
+		{"HIGH_CVSS", &c.Policy.HighCVSS},
+		{"CRITICAL_CVSS", &c.Policy.CriticalCVSS},
+		{"HIGH_SECURITY_FIX_DEADLINE", &c.Policy.HighDeadline},
+		{"CRITICAL_SECURITY_FIX_DEADLINE", &c.Policy.CriticalDeadline},
+	}
+}
+
+// loadConfig reads the config file over the defaults, if a path is given, then applies the environment overrides.
+func loadConfig(path string, lookupEnv func(string) (string, bool)) (config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("Failed to parse %s: %v", path, err)
+		}
+	}
+
+	for _, override := range cfg.envOverrides() {
+		v, ok := lookupEnv(override.name)
+		if !ok || v == "" {
+			continue
+		}
+
+		var err error
+		switch value := override.value.(type) {
+		case *string:
+			*value = v
+		case *int:
+			*value, err = strconv.Atoi(v)
+		case *float64:
+			*value, err = strconv.ParseFloat(v, 64)
+		case *duration:
+			err = value.Set(v)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("Invalid value for %s: %v", override.name, err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c config) validate() error {
+	var problems []string
+	if c.Port <= 0 {
+		problems = append(problems, "port must be positive")
+	}
+	if c.UpdateConf == "" || c.ReleaseConf == "" {
+		problems = append(problems, "updateConf and releaseConf must be set")
+	}
+	if c.PollInterval <= 0 || c.RefreshMinInterval < 0 || c.MaxDataAge <= 0 || c.BundleMaxAge <= 0 {
+		problems = append(problems, "pollInterval, maxDataAge and bundleMaxAge must be positive, and refreshMinInterval must not be negative")
+	}
+	if c.PollTimeout <= 0 || c.CVEWorkers <= 0 || c.DrainTimeout <= 0 {
+		problems = append(problems, "pollTimeout, cveWorkers and drainTimeout must be positive")
+	}
+	if c.HTTP.Timeout <= 0 || c.HTTP.RetryMax < 0 || c.HTTP.RetryWaitMin > c.HTTP.RetryWaitMax {
+		problems = append(problems, "http timeout must be positive, retryMax must not be negative, and retryWaitMin must not exceed retryWaitMax")
+	}
+	if !strings.Contains(c.Sources.CVE, "%s") {
+		problems = append(problems, "sources cve must contain %s for the CVE ID")
+	}
+	if c.Sources.All == "" || c.Sources.Alpha == "" || c.Sources.Beta == "" || c.Sources.Stable == "" {
+		problems = append(problems, "all the release sources must be set")
+	}
+	problems = append(problems, c.Groups.validate()...)
+	if c.Policy.HighCVSS > c.Policy.CriticalCVSS || c.Policy.HighDeadline <= 0 || c.Policy.CriticalDeadline <= 0 {
+		problems = append(problems, "policy highCvss must not exceed criticalCvss, and the deadlines must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// retryableClient builds the client used to retrieve the release feeds and CVEs.
+func (h httpConfig) retryableClient() *retryablehttp.Client {
+	client := newRetryableClient(&http.Client{Timeout: time.Duration(h.Timeout)})
+	client.RetryMax = h.RetryMax
+	client.RetryWaitMin = time.Duration(h.RetryWaitMin)
+	client.RetryWaitMax = time.Duration(h.RetryWaitMax)
+	return client
+}
+
+// configWatcher reloads the config when the file changes or on SIGHUP, and hands every valid config to apply. An
+// invalid config is logged and the previous one is kept.
+type configWatcher struct {
+	sync.RWMutex
+	path    string
+	load    func() (config, error)
+	apply   func(config)
+	current config
+	modTime time.Time
+}
+
+func newConfigWatcher(path string, current config, load func() (config, error), apply func(config)) *configWatcher {
+	w := &configWatcher{path: path, load: load, apply: apply, current: current}
+	w.modTime = w.fileModTime()
+	return w
+}
+
+// Current returns the config which was last applied.
+func (w *configWatcher) Current() config {
+	w.RLock()
+	defer w.RUnlock()
+	return w.current
+}
+
+// Watch checks the config file for changes every interval, and reloads it when it has changed or when a signal is
+// received, until the context is done.
+func (w *configWatcher) Watch(ctx context.Context, interval time.Duration, signals <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			log.WithField("signal", sig.String()).Info("Reloading the config.")
+			w.Reload()
+		case <-ticker.C:
+			if modTime := w.fileModTime(); !modTime.Equal(w.modTime) {
+				w.modTime = modTime
+				log.WithField("config", w.path).Info("The config file has changed, reloading.")
+				w.Reload()
+			}
+		}
+	}
+}
+
+// Reload loads and applies the config, keeping the current one if the new one is invalid.
+func (w *configWatcher) Reload() {
+	cfg, err := w.load()
+	if err != nil {
+		log.WithError(err).WithField("config", w.path).Error("Failed to reload the config, keeping the current one.")
+		return
+	}
+
+	w.Lock()
+	w.current = cfg
+	w.Unlock()
+	w.apply(cfg)
+}
+
+// fileModTime follows symlinks, so the atomic updates of a mounted ConfigMap are picked up.
+func (w *configWatcher) fileModTime() time.Time {
+	if w.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfig(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig("", noEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultConfig(), cfg)
+	assert.Equal(t, stableReleasesURI, cfg.Sources.Stable)
+	assert.Equal(t, duration(time.Minute*30), cfg.PollInterval)
+}
+
+func TestLoadConfigFileAndEnvOverrides(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, `
+port: 9090
+pollInterval: 10m
+http:
+  timeout: 5s
+  retryMax: 2
+sources:
+  cve: https://cve.example.com/api/cve/%s
+policy:
+  highDeadline: 168h
+`)
+
+	env := map[string]string{"POLL_INTERVAL": "15m", "CRITICAL_CVSS": "9.5"}
+	cfg, err := loadConfig(path, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, duration(time.Minute*15), cfg.PollInterval, "the environment overrides the file")
+	assert.Equal(t, duration(time.Second*5), cfg.HTTP.Timeout)
+	assert.Equal(t, 2, cfg.HTTP.RetryMax)
+	assert.Equal(t, duration(100*time.Millisecond), cfg.HTTP.RetryWaitMin, "unset settings keep their defaults")
+	assert.Equal(t, "https://cve.example.com/api/cve/%s", cfg.Sources.CVE)
+	assert.Equal(t, duration(time.Hour*168), cfg.Policy.HighDeadline)
+	assert.Equal(t, 9.5, cfg.Policy.CriticalCVSS)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+
+	_, err := loadConfig(writeTestConfig(t, dir, "pollIntervall: 10m\n"), noEnv)
+	assert.Error(t, err, "unknown settings are rejected")
+
+	_, err = loadConfig(writeTestConfig(t, dir, "pollInterval: soon\n"), noEnv)
+	assert.Error(t, err)
+
+	_, err = loadConfig(writeTestConfig(t, dir, "sources:\n  cve: https://cve.example.com\n"), noEnv)
+	assert.Error(t, err)
+
+	_, err = loadConfig("", func(name string) (string, bool) { return "many", name == "HTTP_RETRY_MAX" })
+	assert.Error(t, err)
+
+	_, err = loadConfig(filepath.Join(dir, "missing.yaml"), noEnv)
+	assert.Error(t, err)
+}
+
+func TestConfigWatcherReloadsOnChangeAndSignal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, "pollInterval: 10m\n")
+	load := func() (config, error) { return loadConfig(path, noEnv) }
+	cfg, err := load()
+	assert.NoError(t, err)
+
+	applied := make(chan config, 1)
+	w := newConfigWatcher(path, cfg, load, func(c config) { applied <- c })
+
+	signals := make(chan os.Signal, 1)
+	go w.Watch(context.Background(), 10*time.Millisecond, signals)
+
+	writeTestConfig(t, dir, "pollInterval: 20m\n")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	select {
+	case c := <-applied:
+		assert.Equal(t, duration(time.Minute*20), c.PollInterval)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The changed config file was not reloaded")
+	}
+
+	// an invalid config is not applied
+	writeTestConfig(t, dir, "pollInterval: -1m\n")
+	signals <- syscall.SIGHUP
+	writeTestConfig(t, dir, "pollInterval: 30m\n")
+	signals <- syscall.SIGHUP
+	select {
+	case c := <-applied:
+		assert.Equal(t, duration(time.Minute*30), c.PollInterval)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The config was not reloaded on SIGHUP")
+	}
+	assert.Equal(t, duration(time.Minute*30), w.Current().PollInterval)
+}
+
+func TestReleaseRepositoryConfigure(t *testing.T) {
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	assert.Equal(t, defaultReleaseSources(), repo.settings().sources)
+
+	cfg := defaultConfig()
+	cfg.UpdateConf = "/etc/coreos/other.conf"
+	cfg.Sources.Stable = "https://releases.example.com/stable.json"
+	cfg.HTTP.RetryMax = 1
+	repo.Configure(cfg)
+
+	settings := repo.settings()
+	assert.Equal(t, "/etc/coreos/other.conf", settings.updateConfPath)
+	assert.Equal(t, "https://releases.example.com/stable.json", settings.sources.Stable)
+	assert.Equal(t, 1, settings.client.RetryMax)
+}
+
+func TestSecurityPolicyDeadlines(t *testing.T) {
+	defer setSecurityPolicy(defaultSecurityPolicy())
+
+	released := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	cvss := 8.0
+	release := coreOSRelease{MaxCVSS: &cvss, ReleaseDate: &released}
+
+	deadline, ok := securityDeadline(release)
+	assert.True(t, ok)
+	assert.Equal(t, released.Add(highSecurityFixDeadline), deadline)
+
+	policy := defaultSecurityPolicy()
+	policy.CriticalCVSS = 8
+	setSecurityPolicy(policy)
+
+	deadline, ok = securityDeadline(release)
+	assert.True(t, ok)
+	assert.Equal(t, released.Add(criticalSecurityFixDeadline), deadline)
+}
+
+func TestGroupMappings(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+
+	cfg, err := loadConfig(writeTestConfig(t, dir, `
+groups:
+- group: coreUpdateChan1
+  channel: stable
+- pattern: coreUpdateBeta[0-9]+
+  channel: beta
+  source: https://releases.example.com/beta.json
+`), noEnv)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		group, channel, source string
+		mapped                 bool
+	}{
+		{"alpha", "alpha", "", true},
+		{"coreUpdateChan1", "stable", "", true},
+		{"coreUpdateBeta2", "beta", "https://releases.example.com/beta.json", true},
+		{"xcoreUpdateBeta2", "", "", false},
+		{"coreUpdateChan2", "", "", false},
+	} {
+		channel, source, mapped := cfg.Groups.resolve(tc.group)
+		assert.Equal(t, tc.channel, channel, tc.group)
+		assert.Equal(t, tc.source, source, tc.group)
+		assert.Equal(t, tc.mapped, mapped, tc.group)
+	}
+
+	_, err = loadConfig(writeTestConfig(t, dir, "groups:\n- group: a\n  pattern: b\n  channel: stable\n"), noEnv)
+	assert.Error(t, err)
+	_, err = loadConfig(writeTestConfig(t, dir, "groups:\n- pattern: '[a'\n  channel: stable\n"), noEnv)
+	assert.Error(t, err)
+	_, err = loadConfig(writeTestConfig(t, dir, "groups:\n- group: a\n  channel: edge\n"), noEnv)
+	assert.Error(t, err)
+}
+
+func TestConfigWatcherStopsWhenCancelled(t *testing.T) {
+	w := newConfigWatcher("", config{}, func() (config, error) { return config{}, nil }, func(config) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Watch(ctx, time.Hour, make(chan os.Signal))
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The watcher did not stop when the context was cancelled")
+	}
+}
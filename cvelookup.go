@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var errCVEDeadline = errors.New("The CVE could not be retrieved before the poll deadline")
+
+// cveLookup retrieves the CVEs of a poll with a bounded number of concurrent requests. Each CVE is retrieved once per
+// poll, however many releases fix it, and the lookups stop at the poll deadline.
+type cveLookup struct {
+	sync.Mutex
+	ctx     context.Context
+	source  releaseSource
+	uri     string
+	slots   chan struct{}
+	lookups map[string]*pendingCVE
+}
+
+type pendingCVE struct {
+	done   chan struct{}
+	result cve
+}
+
+func newCVELookup(ctx context.Context, source releaseSource, uri string, workers int) *cveLookup {
+	return &cveLookup{
+		ctx:     ctx,
+		source:  source,
+		uri:     uri,
+		slots:   make(chan struct{}, workers),
+		lookups: make(map[string]*pendingCVE),
+	}
+}
+
+// Lookup returns the CVEs in the order of the IDs. A CVE which has not been retrieved by the deadline is returned
+// with errCVEDeadline, so the release is reported with the CVEs which were retrieved.
+func (l *cveLookup) Lookup(ids []string) []cve {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	pending := make([]*pendingCVE, len(ids))
+	for i, id := range ids {
+		pending[i] = l.start(id)
+	}
+
+	fixes := make([]cve, len(ids))
+	for i, p := range pending {
+		// a CVE already retrieved is kept even once the deadline has passed, select would pick either at random
+		select {
+		case <-p.done:
+			fixes[i] = p.result
+			continue
+		default:
+		}
+		select {
+		case <-p.done:
+			fixes[i] = p.result
+		case <-l.ctx.Done():
+			fixes[i] = cve{ID: ids[i], err: errCVEDeadline}
+		}
+	}
+	return fixes
+}
+
+func (l *cveLookup) start(id string) *pendingCVE {
+	l.Lock()
+	defer l.Unlock()
+
+	if p, ok := l.lookups[id]; ok {
+		return p
+	}
+
+	p := &pendingCVE{done: make(chan struct{})}
+	l.lookups[id] = p
+	go func() {
+		defer close(p.done)
+		// a panic retrieving one CVE fails that CVE rather than taking down the process
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithField("stack", string(debug.Stack())).Errorf("The retrieval of %v panicked: %v", id, r)
+				p.result = cve{ID: id, err: fmt.Errorf("The CVE could not be retrieved: %v", r)}
+			}
+		}()
+		select {
+		case l.slots <- struct{}{}:
+			defer func() { <-l.slots }()
+		case <-l.ctx.Done():
+			p.result = cve{ID: id, err: errCVEDeadline}
+			return
+		}
+		p.result = retrieveCVE(l.ctx, l.source, l.uri, id)
+	}()
+	return p
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowCVESource answers every CVE with a CVSS of 5 after the delay, recording the requests and the most which were
+// in flight at once.
+type slowCVESource struct {
+	sync.Mutex
+	delay       time.Duration
+	requests    map[string]int
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *slowCVESource) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	s.Lock()
+	s.requests[uri]++
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.Lock()
+	s.inFlight--
+	s.Unlock()
+	return ioutil.NopCloser(strings.NewReader(`{"cvss": "5.0"}`)), nil
+}
+
+func TestCVELookupBoundedAndDeduplicated(t *testing.T) {
+	source := &slowCVESource{delay: 10 * time.Millisecond, requests: make(map[string]int)}
+	lookup := newCVELookup(context.Background(), source, cveURI, 3)
+
+	var ids []string
+	for i := 0; i < 10; i++ {
+		ids = append(ids, fmt.Sprintf("CVE-2019-%04d", i))
+	}
+
+	var wg sync.WaitGroup
+	for _, release := range [][]string{ids, ids[5:]} {
+		wg.Add(1)
+		go func(ids []string) {
+			defer wg.Done()
+			fixes := lookup.Lookup(ids)
+			assert.Len(t, fixes, len(ids))
+			for i, fix := range fixes {
+				assert.Equal(t, cve{ID: ids[i], CVSS: 5}, fix)
+			}
+		}(release)
+	}
+	wg.Wait()
+
+	assert.Len(t, source.requests, 10)
+	for uri, count := range source.requests {
+		assert.Equal(t, 1, count, uri)
+	}
+	assert.True(t, source.maxInFlight > 1 && source.maxInFlight <= 3, "at most 3 CVEs are retrieved at once")
+}
+
+// stuckCVESource only answers the fast CVEs, the others hang until released.
+type stuckCVESource struct {
+	fast     map[string]bool
+	released chan struct{}
+}
+
+func (s *stuckCVESource) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	if !s.fast[uri] {
+		<-s.released
+	}
+	return ioutil.NopCloser(strings.NewReader(`{"cvss": "5.0"}`)), nil
+}
+
+func TestCVELookupDeadline(t *testing.T) {
+	source := &stuckCVESource{fast: map[string]bool{fmt.Sprintf(cveURI, "CVE-2019-0001"): true}, released: make(chan struct{})}
+	defer close(source.released)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// a slot for every CVE, so the stuck CVEs can't hold up the fast one
+	lookup := newCVELookup(ctx, source, cveURI, 3)
+
+	fixes := lookup.Lookup([]string{"CVE-2019-0001", "CVE-2019-0002", "CVE-2019-0003"})
+	assert.Equal(t, cve{ID: "CVE-2019-0001", CVSS: 5}, fixes[0], "the CVEs retrieved before the deadline are kept")
+	assert.Equal(t, errCVEDeadline, fixes[1].err)
+	assert.Equal(t, errCVEDeadline, fixes[2].err)
+
+	release := coreOSRelease{SecurityFixes: fixes}
+	assert.False(t, release.enriched())
+}
+
+// panickingCVESource panics for every CVE.
+type panickingCVESource struct{}
+
+func (panickingCVESource) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	panic("unexpected document")
+}
+
+func TestCVELookupRecoversPanic(t *testing.T) {
+	lookup := newCVELookup(context.Background(), panickingCVESource{}, cveURI, 1)
+
+	fixes := lookup.Lookup([]string{"CVE-2019-0001"})
+	assert.Equal(t, "CVE-2019-0001", fixes[0].ID)
+	assert.EqualError(t, fixes[0].err, "The CVE could not be retrieved: unexpected document")
+}
+
+func TestCVELookupKeepsRetrievedAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lookup := newCVELookup(ctx, &slowCVESource{requests: make(map[string]int)}, cveURI, 1)
+	assert.Equal(t, cve{ID: "CVE-2019-0001", CVSS: 5}, lookup.Lookup([]string{"CVE-2019-0001"})[0])
+
+	cancel()
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, cve{ID: "CVE-2019-0001", CVSS: 5}, lookup.Lookup([]string{"CVE-2019-0001"})[0], "the CVE was retrieved before the deadline")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	cli "github.com/jawher/mow.cli"
+)
+
+// releaseDiff describes what changes when upgrading from one release of a channel to another.
+type releaseDiff struct {
+	Channel       string
+	From          string
+	To            string
+	Releases      []coreOSRelease
+	SecurityFixes []cve
+	Packages      []packageChange
+}
+
+// packageChange is a change in the version of one of the major software packages shipped with the release.
+type packageChange struct {
+	Name string
+	From string
+	To   string
+}
+
+func diffCommand(cmd *cli.Cmd) {
+	cmd.Spec = "[--channel] FROM TO"
+
+	channel := cmd.String(cli.StringOpt{
+		Name:  "channel c",
+		Value: "stable",
+		Desc:  "The channel whose releases are compared, alpha, beta or stable.",
+	})
+	from := cmd.String(cli.StringArg{
+		Name: "FROM",
+		Desc: "The installed version, e.g. 2135.4.0",
+	})
+	to := cmd.String(cli.StringArg{
+		Name: "TO",
+		Desc: "The version to upgrade to, e.g. 2191.5.0",
+	})
+
+	cmd.Action = func() {
+		log.SetLevel(log.WarnLevel)
+
+		cfg, err := loadCheckerConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load the config: %v\n", err)
+			cli.Exit(1)
+		}
+
+		repo, err := newCheckerRepository(&http.Client{Timeout: time.Duration(cfg.HTTP.Timeout)}, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load the offline bundle: %v\n", err)
+			cli.Exit(1)
+		}
+
+		diff, err := repo.Diff(context.Background(), *channel, *from, *to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compare %s with %s: %v\n", *from, *to, err)
+			cli.Exit(1)
+		}
+		if err := writeReleaseDiff(os.Stdout, diff); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the diff: %v\n", err)
+			cli.Exit(1)
+		}
+	}
+}
+
+// Diff retrieves every release of the channel after from, up to and including to, with the union of their security
+// fixes and the package versions which changed between the two.
+func (r *releaseRepository) Diff(ctx context.Context, channel string, from string, to string) (*releaseDiff, error) {
+	if compareVersions(from, to) >= 0 {
+		return nil, fmt.Errorf("%s is not older than %s", from, to)
+	}
+
+	settings := r.settings()
+	uri, err := settings.sources.channel(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, _, err := getReleaseFeed(ctx, settings.source, uri, feedValidators{}, func(version string) bool {
+		return compareVersions(version, from) >= 0 && compareVersions(version, to) <= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range []string{from, to} {
+		if _, ok := releases[version]; !ok {
+			return nil, fmt.Errorf("Release %s not found in the %s channel", version, channel)
+		}
+	}
+
+	var versions []string
+	for version := range releases {
+		if compareVersions(version, from) > 0 && compareVersions(version, to) <= 0 {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+
+	diff := &releaseDiff{Channel: channel, From: from, To: to}
+	fixes := make(map[string]cve)
+	for _, version := range versions {
+		release, err := r.GetReleaseData(ctx, version, releases)
+		if err != nil {
+			return nil, err
+		}
+		diff.Releases = append(diff.Releases, *release)
+		for _, fix := range release.SecurityFixes {
+			fixes[fix.ID] = fix
+		}
+	}
+
+	for _, fix := range fixes {
+		diff.SecurityFixes = append(diff.SecurityFixes, fix)
+	}
+	sort.Slice(diff.SecurityFixes, func(i, j int) bool {
+		a, b := diff.SecurityFixes[i], diff.SecurityFixes[j]
+		if a.CVSS != b.CVSS {
+			return a.CVSS > b.CVSS
+		}
+		return a.ID < b.ID
+	})
+
+	diff.Packages = packageChanges(releases[from], releases[to])
+	return diff, nil
+}
+
+// packageChanges compares the major_software listed for the two releases in the feed.
+func packageChanges(from feedRelease, to feedRelease) []packageChange {
+	fromPackages := majorSoftware(from)
+	toPackages := majorSoftware(to)
+
+	names := make(map[string]struct{})
+	for name := range fromPackages {
+		names[name] = struct{}{}
+	}
+	for name := range toPackages {
+		names[name] = struct{}{}
+	}
+
+	var changes []packageChange
+	for name := range names {
+		if fromPackages[name] != toPackages[name] {
+			changes = append(changes, packageChange{Name: name, From: fromPackages[name], To: toPackages[name]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+func majorSoftware(release feedRelease) map[string]string {
+	packages := make(map[string]string)
+	for name, versions := range release.MajorSoftware {
+		packages[name] = strings.Join(versions, ", ")
+	}
+	return packages
+}
+
+func writeReleaseDiff(w io.Writer, diff *releaseDiff) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CoreOS %s %s -> %s (%d release(s))\n", diff.Channel, diff.From, diff.To, len(diff.Releases))
+
+	b.WriteString("\nPackage changes:\n")
+	if len(diff.Packages) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, p := range diff.Packages {
+		fmt.Fprintf(&b, "  %s: %s -> %s\n", p.Name, orNone(p.From), orNone(p.To))
+	}
+
+	b.WriteString("\nSecurity fixes:\n")
+	if len(diff.SecurityFixes) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, fix := range diff.SecurityFixes {
+		cvss := fmt.Sprintf("CVSS %.1f", fix.CVSS)
+		if fix.err != nil {
+			cvss = "CVSS unknown"
+		}
+		fmt.Fprintf(&b, "  %s (%s) %s\n", fix.ID, cvss, fmt.Sprintf(cveLinkURI, fix.ID))
+	}
+
+	b.WriteString("\nRelease notes:\n")
+	for _, release := range diff.Releases {
+		fmt.Fprintf(&b, "\n%s", release.Version)
+		if release.ReleaseDate != nil {
+			fmt.Fprintf(&b, " (released %s)", release.ReleaseDate.UTC().Format(releaseDateFormat))
+		}
+		fmt.Fprintf(&b, "\n%s\n", strings.TrimSpace(release.ReleaseNotes))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func orNone(version string) string {
+	if version == "" {
+		return "(none)"
+	}
+	return version
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+const diffReleasesHTTPResponse = `{
+	"2135.4.0": {"version": "2135.4.0", "release_notes": "Initial release", "release_date": "2019-06-25 20:35:59 +0000",
+		"major_software": {"kernel": ["4.19.50"], "docker": ["18.06.3"], "rkt": ["1.30.0"]}},
+	"2135.5.0": {"version": "2135.5.0", "release_notes": "Fix CVE-2019-0001 and CVE-2019-0002", "release_date": "2019-07-02 20:35:59 +0000",
+		"major_software": {"kernel": ["4.19.56"], "docker": ["18.06.3"], "rkt": ["1.30.0"]}},
+	"2135.6.0": {"version": "2135.6.0", "release_notes": "Fix CVE-2019-0002 again", "release_date": "2019-07-09 20:35:59 +0000",
+		"major_software": {"kernel": ["4.19.56"], "docker": ["18.06.3"], "ignition": ["0.32.0"]}},
+	"2191.4.0": {"version": "2191.4.0", "release_notes": "Newer", "major_software": {}}
+}`
+
+func TestReleaseDiff(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", stableReleasesURI, httpmock.NewStringResponder(200, diffReleasesHTTPResponse))
+	httpmock.RegisterResponder("GET", "http://cve.circl.lu/api/cve/CVE-2019-0001",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, `{"cvss": "5.0"}`), nil
+		},
+	)
+	httpmock.RegisterResponder("GET", "http://cve.circl.lu/api/cve/CVE-2019-0002",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, `{"cvss": "9.8"}`), nil
+		},
+	)
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	diff, err := repo.Diff(context.Background(), "stable", "2135.4.0", "2135.6.0")
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, writeReleaseDiff(&out, diff))
+	assert.Equal(t, `CoreOS stable 2135.4.0 -> 2135.6.0 (2 release(s))
+
+Package changes:
+  ignition: (none) -> 0.32.0
+  kernel: 4.19.50 -> 4.19.56
+  rkt: 1.30.0 -> (none)
+
+Security fixes:
+  CVE-2019-0002 (CVSS 9.8) https://cve.circl.lu/cve/CVE-2019-0002
+  CVE-2019-0001 (CVSS 5.0) https://cve.circl.lu/cve/CVE-2019-0001
+
+Release notes:
+
+2135.5.0 (released 2019-07-02 20:35 UTC)
+Fix CVE-2019-0001 and CVE-2019-0002
+
+2135.6.0 (released 2019-07-09 20:35 UTC)
+Fix CVE-2019-0002 again
+`, out.String())
+}
+
+func TestReleaseDiffErrors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", stableReleasesURI,
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, diffReleasesHTTPResponse), nil
+		},
+	)
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+
+	_, err := repo.Diff(context.Background(), "stable", "2135.6.0", "2135.4.0")
+	assert.EqualError(t, err, "2135.6.0 is not older than 2135.4.0")
+
+	_, err = repo.Diff(context.Background(), "stable", "2135.4.0", "2247.1.0")
+	assert.EqualError(t, err, "Release 2247.1.0 not found in the stable channel")
+
+	_, err = repo.Diff(context.Background(), "edge", "2135.4.0", "2135.6.0")
+	assert.EqualError(t, err, "Unknown channel")
+}
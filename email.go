@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const digestTextTemplate = `CoreOS upgrade status as of {{ .Generated.UTC.Format "2006-01-02 15:04 MST" }}
+
+{{ .Pending }} of {{ len .Nodes }} node(s) have an upgrade pending.
+{{ range .Nodes }}
+{{ .Node }} ({{ .Channel }} channel{{ if .Board }}, {{ .Board }} board{{ end }})
+  Installed: {{ .Installed }}
+  Latest:    {{ .Latest }}{{ if .UpToDate }} (up to date){{ end }}
+{{- if .Deadline }}
+  Deadline:  {{ .Deadline.UTC.Format "2006-01-02 15:04 MST" }}{{ if .Overdue }} - OVERDUE{{ end }}
+{{- end }}
+{{- range .SecurityFixes }}
+  {{ .ID }} (CVSS {{ printf "%.1f" .CVSS }}) {{ cveLink .ID }}
+{{- end }}
+{{ end }}`
+
+const digestHTMLTemplate = `<html>
+<body>
+<h2>CoreOS upgrade status as of {{ .Generated.UTC.Format "2006-01-02 15:04 MST" }}</h2>
+<p>{{ .Pending }} of {{ len .Nodes }} node(s) have an upgrade pending.</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Node</th><th>Channel</th><th>Board</th><th>Installed</th><th>Latest</th><th>Deadline</th><th>Security Fixes</th></tr>
+{{- range .Nodes }}
+<tr>
+<td>{{ .Node }}</td>
+<td>{{ .Channel }}</td>
+<td>{{ .Board }}</td>
+<td>{{ .Installed }}</td>
+<td>{{ .Latest }}{{ if .UpToDate }} (up to date){{ end }}</td>
+<td>{{ if .Deadline }}{{ if .Overdue }}<b style="color: red">{{ .Deadline.UTC.Format "2006-01-02 15:04 MST" }} OVERDUE</b>{{ else }}{{ .Deadline.UTC.Format "2006-01-02 15:04 MST" }}{{ end }}{{ end }}</td>
+<td>{{ range .SecurityFixes }}<a href="{{ cveLink .ID }}">{{ .ID }}</a> ({{ printf "%.1f" .CVSS }})<br>{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`
+
+var (
+	digestFuncs = map[string]interface{}{"cveLink": func(id string) string { return fmt.Sprintf(cveLinkURI, id) }}
+	digestText  = template.Must(template.New("text").Funcs(digestFuncs).Parse(digestTextTemplate))
+	digestHTML  = htmltemplate.Must(htmltemplate.New("html").Funcs(digestFuncs).Parse(digestHTMLTemplate))
+)
+
+type digest struct {
+	Generated time.Time
+	Pending   int
+	Nodes     []nodeDigest
+}
+
+type nodeDigest struct {
+	Node          string
+	Channel       string
+	Board         string
+	Installed     string
+	Latest        string
+	UpToDate      bool
+	SecurityFixes []cve
+	Deadline      *time.Time
+	Overdue       bool
+}
+
+type smtpConfig struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// emailDigest periodically emails a summary of the pending upgrades, security fixes and deadlines of the fleet, as
+// last collected by the aggregator, rendered as both plain text and HTML.
+type emailDigest struct {
+	sync.Mutex
+	config   smtpConfig
+	interval time.Duration
+	states   map[string]releaseState
+	now      func() time.Time
+}
+
+func newEmailDigest(config smtpConfig, interval time.Duration) *emailDigest {
+	return &emailDigest{
+		config:   config,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Observe is registered with the aggregator to be called after every collection. The nodes whose checker has not
+// retrieved the releases yet are left out.
+func (d *emailDigest) Observe(ctx context.Context, nodes map[string]nodeStatus) {
+	states := make(map[string]releaseState)
+	for name, node := range nodes {
+		if node.LastSuccess != nil {
+			states[name] = node.releaseState
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.states = states
+}
+
+// Start sends a digest every interval, until the context is done.
+func (d *emailDigest) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.Send(); err != nil {
+				log.WithError(err).Error("Failed to send the email digest.")
+			}
+		}
+	}
+}
+
+// Send emails a digest of the fleet, if the release information of any node has been collected.
+func (d *emailDigest) Send() error {
+	d.Lock()
+	states := d.states
+	d.Unlock()
+
+	if len(states) == 0 {
+		log.Info("Skipping the email digest as no release information has been collected yet.")
+		return nil
+	}
+
+	msg, err := d.render(newDigest(d.now(), states))
+	if err != nil {
+		return err
+	}
+	return sendMail(d.config, msg)
+}
+
+func newDigest(now time.Time, states map[string]releaseState) digest {
+	nodes := make([]string, 0, len(states))
+	for node := range states {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	dg := digest{Generated: now}
+	for _, node := range nodes {
+		state := states[node]
+		nd := nodeDigest{
+			Node:      node,
+			Channel:   state.Channel,
+			Board:     state.Board,
+			Installed: state.InstalledVersion.Version,
+			Latest:    state.LatestVersion.Version,
+			UpToDate:  state.InstalledVersion.Version == state.LatestVersion.Version,
+		}
+		if !nd.UpToDate {
+			dg.Pending++
+			nd.SecurityFixes = state.LatestVersion.SecurityFixes
+			if deadline, ok := securityDeadline(state.LatestVersion); ok {
+				nd.Deadline = &deadline
+				nd.Overdue = now.After(deadline)
+			}
+		}
+		dg.Nodes = append(dg.Nodes, nd)
+	}
+	return dg
+}
+
+func (d *emailDigest) render(dg digest) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, dg); err != nil {
+		return nil, err
+	}
+	if err := digestHTML.Execute(&html, dg); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + d.config.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(d.config.To, ", ") + "\r\n")
+	msg.WriteString("Subject: CoreOS upgrade digest: " + digestSubject(dg) + "\r\n")
+	msg.WriteString("Date: " + dg.Generated.Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: multipart/alternative; boundary=" + parts.Boundary() + "\r\n\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", text.Bytes()},
+		{"text/html; charset=UTF-8", html.Bytes()},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	parts.Close()
+
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func digestSubject(dg digest) string {
+	overdue := 0
+	for _, node := range dg.Nodes {
+		if node.Overdue {
+			overdue++
+		}
+	}
+	if overdue > 0 {
+		return fmt.Sprintf("%d upgrade(s) pending, %d overdue", dg.Pending, overdue)
+	}
+	if dg.Pending > 0 {
+		return fmt.Sprintf("%d upgrade(s) pending", dg.Pending)
+	}
+	return "all nodes up to date"
+}
+
+func sendMail(config smtpConfig, msg []byte) error {
+	var auth smtp.Auth
+	if config.Username != "" {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", config.Username, config.Password, host)
+	}
+	return smtp.SendMail(config.Addr, auth, config.From, config.To, msg)
+}
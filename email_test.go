@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// startFakeSMTPServer accepts a single SMTP session and sends the received mail on the returned channel.
+func startFakeSMTPServer(t *testing.T) (string, chan receivedMail) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	received := make(chan receivedMail, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost fake SMTP")
+
+		var m receivedMail
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				m.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				m.data = data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				received <- m
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestEmailDigest(t *testing.T) {
+	addr, received := startFakeSMTPServer(t)
+
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	d := newEmailDigest(smtpConfig{Addr: addr, From: "checker@example.com", To: []string{"ops@example.com"}}, time.Hour*24)
+	d.now = func() time.Time { return now }
+
+	assert.NoError(t, d.Send())
+
+	pending := newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour*72))
+	upToDate := newTestState("2135.5.0", "2135.5.0", 9.8, now.Add(-time.Hour*72))
+	unknown := releaseState{}
+	d.Observe(context.Background(), map[string]nodeStatus{
+		"node-1": {Node: "node-1", releaseState: pending},
+		"node-2": {Node: "node-2", releaseState: upToDate},
+		"node-3": {Node: "node-3", releaseState: unknown},
+	})
+	assert.NoError(t, d.Send())
+
+	var m receivedMail
+	select {
+	case m = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No mail received")
+	}
+
+	assert.Equal(t, "checker@example.com", m.from)
+	assert.Equal(t, []string{"ops@example.com"}, m.to)
+
+	msg, err := mail.ReadMessage(strings.NewReader(m.data))
+	assert.NoError(t, err)
+	assert.Equal(t, "CoreOS upgrade digest: 1 upgrade(s) pending, 1 overdue", msg.Header.Get("Subject"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+
+	text, err := parts.NextPart()
+	assert.NoError(t, err)
+	content, _ := ioutil.ReadAll(text)
+	assert.Contains(t, string(content), "1 of 2 node(s) have an upgrade pending.")
+	assert.Contains(t, string(content), "node-1 (stable channel)")
+	assert.Contains(t, string(content), "node-2 (stable channel)")
+	assert.NotContains(t, string(content), "node-3")
+	assert.Contains(t, string(content), "Deadline:  2019-06-30 00:00 UTC - OVERDUE")
+	assert.Contains(t, string(content), "CVE-2019-0001 (CVSS 9.8) https://cve.circl.lu/cve/CVE-2019-0001")
+
+	html, err := parts.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "text/html; charset=UTF-8", html.Header.Get("Content-Type"))
+	content, _ = ioutil.ReadAll(html)
+	assert.Contains(t, string(content), `<a href="https://cve.circl.lu/cve/CVE-2019-0001">CVE-2019-0001</a> (9.8)`)
+}
+
+func TestDigestSubject(t *testing.T) {
+	now := time.Now()
+	dg := newDigest(now, map[string]releaseState{
+		"node-1": newTestState("2135.5.0", "2135.5.0", -1, now),
+		"node-2": newTestState("2135.4.0", "2135.5.0", 5, now),
+	})
+	assert.Equal(t, "1 upgrade(s) pending", digestSubject(dg))
+	assert.Equal(t, "node-1", dg.Nodes[0].Node)
+	assert.True(t, dg.Nodes[0].UpToDate)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultUpdateConfDefaultsPath = "/usr/share/coreos/update.conf"
+	defaultOSReleasePath          = "/etc/os-release"
+)
+
+var envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvFile parses the shell style KEY=value files used by CoreOS, such as update.conf, the release file and
+// os-release. Values may be single quoted, double quoted or unquoted, and blank lines and comments are ignored. A
+// later assignment of the same key overrides an earlier one.
+func parseEnvFile(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(strings.TrimSuffix(scanner.Text(), "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+
+		key := strings.TrimSpace(line[:i])
+		if !envKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNo, key)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// parseEnvValue unquotes a value the way the shell would, for the quoting used in practice: single quotes are
+// literal, double quotes and unquoted values support backslash escapes, and a # after whitespace starts a comment.
+// Unquoted whitespace within the value is kept literally, as locksmith reads e.g. REBOOT_WINDOW_START=Thu 04:00.
+func parseEnvValue(raw string) (string, error) {
+	var value strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated single quote")
+			}
+			value.WriteString(raw[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			closed := false
+			for i++; i < len(raw); i++ {
+				if raw[i] == '"' {
+					closed = true
+					break
+				}
+				if raw[i] == '\\' && i+1 < len(raw) && strings.IndexByte("\"\\$`", raw[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(raw[i])
+			}
+			if !closed {
+				return "", fmt.Errorf("unterminated double quote")
+			}
+		case c == '\\' && i+1 < len(raw):
+			i++
+			value.WriteByte(raw[i])
+		case c == ' ' || c == '\t':
+			// the rest of the line up to a comment is taken as it is
+			value.WriteString(strings.TrimRight(stripEnvComment(raw[i:]), " \t"))
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return value.String(), nil
+}
+
+// stripEnvComment removes a trailing comment, which starts with a # at the start or after whitespace.
+func stripEnvComment(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t') {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// readEnvFiles reads the files in order, later files overriding the keys of earlier ones, the way update_engine
+// layers /etc/coreos/update.conf over /usr/share/coreos/update.conf. Missing files are skipped, but at least one of
+// the files must exist.
+func readEnvFiles(paths ...string) (map[string]string, error) {
+	values := make(map[string]string)
+	found := false
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := parseEnvFile(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+		}
+
+		found = true
+		for key, value := range parsed {
+			values[key] = value
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("None of %s exist", strings.Join(nonEmpty(paths), ", "))
+	}
+	return values, nil
+}
+
+// getValueFromFile returns the value of the key from the layered files.
+func getValueFromFile(key string, paths ...string) (string, error) {
+	values, err := readEnvFiles(paths...)
+	if err != nil {
+		return "", err
+	}
+
+	val, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("No %s in %s", key, strings.Join(nonEmpty(paths), ", "))
+	}
+	return val, nil
+}
+
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
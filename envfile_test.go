@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	content := "# CoreOS update.conf\r\n" +
+		"GROUP=beta\r\n" +
+		"\n" +
+		"  SERVER = \"https://public.update.core-os.net/v1/update/\"  # the default\n" +
+		"REBOOT_STRATEGY='etcd-lock'\n" +
+		"export PRETTY_NAME=\"Container Linux by CoreOS 2135.4.0 (Rhyolite)\"\n" +
+		"ESCAPED=\"say \\\"hi\\\" \\$HOME\"\n" +
+		"UNQUOTED=a\\ b\n" +
+		"EMPTY=\n" +
+		"REBOOT_WINDOW_START=Thu 04:00 # locksmith\n" +
+		"REBOOT_WINDOW_LENGTH=1h\t\n" +
+		"GROUP=stable\n"
+
+	values, err := parseEnvFile(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"GROUP":           "stable",
+		"SERVER":          "https://public.update.core-os.net/v1/update/",
+		"REBOOT_STRATEGY": "etcd-lock",
+		"PRETTY_NAME":     "Container Linux by CoreOS 2135.4.0 (Rhyolite)",
+		"ESCAPED":         `say "hi" $HOME`,
+		"UNQUOTED":        "a b",
+		"EMPTY":           "",
+		// locksmith reads the unquoted value with whitespace as it is
+		"REBOOT_WINDOW_START":  "Thu 04:00",
+		"REBOOT_WINDOW_LENGTH": "1h",
+	}, values)
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	for _, content := range []string{
+		"GROUP\n",
+		"GROUP=\"stable\n",
+		"GROUP='stable\n",
+		"1GROUP=stable\n",
+	} {
+		_, err := parseEnvFile(strings.NewReader(content))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestGetValueFromLayeredFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "envfile")
+	defer os.RemoveAll(dir)
+
+	defaults := filepath.Join(dir, "usr-update.conf")
+	overrides := filepath.Join(dir, "etc-update.conf")
+	ioutil.WriteFile(defaults, []byte("GROUP=stable\nSERVER=https://public.update.core-os.net/v1/update/\n"), 0644)
+	ioutil.WriteFile(overrides, []byte("GROUP=coreUpdateChan1\n"), 0644)
+
+	group, err := getValueFromFile("GROUP", defaults, overrides)
+	assert.NoError(t, err)
+	assert.Equal(t, "coreUpdateChan1", group)
+
+	server, err := getValueFromFile("SERVER", defaults, overrides)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://public.update.core-os.net/v1/update/", server)
+
+	group, err = getValueFromFile("GROUP", filepath.Join(dir, "missing.conf"), defaults)
+	assert.NoError(t, err, "missing layers are skipped")
+	assert.Equal(t, "stable", group)
+
+	_, err = getValueFromFile("COREOS_RELEASE_VERSION", defaults, overrides)
+	assert.EqualError(t, err, "No COREOS_RELEASE_VERSION in "+defaults+", "+overrides)
+
+	_, err = getValueFromFile("GROUP", filepath.Join(dir, "missing.conf"))
+	assert.Error(t, err)
+}
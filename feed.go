@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// releaseFeed holds the releases of a feed of coreos.com by version.
+type releaseFeed map[string]feedRelease
+
+// feedRelease is a release as published in the release feeds.
+type feedRelease struct {
+	Version       string              `json:"version"`
+	ReleaseNotes  string              `json:"release_notes"`
+	ReleaseDate   string              `json:"release_date"`
+	Architectures []string            `json:"architectures"`
+	MajorSoftware map[string][]string `json:"major_software"`
+}
+
+// cveDocument is a CVE as published by the CVE API.
+type cveDocument struct {
+	CVSS *string `json:"cvss"`
+}
+
+// schemaError reports a document which does not have the format expected of it, e.g. after a change of the feeds.
+type schemaError struct {
+	uri string
+	err error
+}
+
+func (e schemaError) Error() string {
+	return fmt.Sprintf("%s does not have the expected format: %v", e.uri, e.err)
+}
+
+// decodeReleaseFeed streams the releases of the feed, only decoding those for which keep returns true, so a large
+// feed such as the one with every release is never held in memory as a whole. A nil keep decodes every release.
+func decodeReleaseFeed(r io.Reader, keep func(version string) bool) (releaseFeed, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	releases := make(releaseFeed)
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		version, _ := token.(string)
+
+		if keep != nil && !keep(version) {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var release feedRelease
+		if err := dec.Decode(&release); err != nil {
+			return nil, fmt.Errorf("release %s: %v", version, err)
+		}
+		releases[version] = release
+	}
+	return releases, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v but found %v", delim, token)
+	}
+	return nil
+}
+
+// getReleaseFeed retrieves and decodes the release feed, with a conditional request if the source supports it. It
+// returns errNotModified if the feed has not changed since it was retrieved with the validators.
+func getReleaseFeed(ctx context.Context, source releaseSource, uri string, validators feedValidators, keep func(version string) bool) (releaseFeed, feedValidators, error) {
+	var body io.ReadCloser
+	var err error
+	if conditional, ok := source.(conditionalSource); ok {
+		body, validators, err = conditional.OpenIfModified(ctx, uri, validators)
+	} else {
+		body, err = source.Open(ctx, uri)
+		validators = feedValidators{}
+	}
+	if err != nil {
+		return nil, validators, err
+	}
+	defer body.Close()
+
+	releases, err := decodeReleaseFeed(body, keep)
+	if err != nil {
+		return nil, validators, schemaError{uri: uri, err: err}
+	}
+	return releases, validators, nil
+}
+
+// getCVE retrieves and decodes the CVE document.
+func getCVE(ctx context.Context, source releaseSource, uri string) (cveDocument, error) {
+	var document cveDocument
+	body, err := source.Open(ctx, uri)
+	if err != nil {
+		return document, err
+	}
+	defer body.Close()
+
+	if err := json.NewDecoder(body).Decode(&document); err != nil {
+		return document, schemaError{uri: uri, err: err}
+	}
+	return document, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestDecodeReleaseFeed(t *testing.T) {
+	feed := `{
+		"2135.4.0": {"version": "2135.4.0", "release_notes": "Fix CVE-2019-0001", "architectures": ["amd64", "arm64"],
+			"major_software": {"kernel": ["4.19.50"]}},
+		"2135.5.0": {"version": "2135.5.0", "release_notes": 42}
+	}`
+
+	releases, err := decodeReleaseFeed(strings.NewReader(feed), func(version string) bool { return version == "2135.4.0" })
+	assert.NoError(t, err)
+	assert.Equal(t, releaseFeed{"2135.4.0": {
+		Version:       "2135.4.0",
+		ReleaseNotes:  "Fix CVE-2019-0001",
+		Architectures: []string{"amd64", "arm64"},
+		MajorSoftware: map[string][]string{"kernel": {"4.19.50"}},
+	}}, releases, "the other releases are skipped without being decoded")
+
+	_, err = decodeReleaseFeed(strings.NewReader(feed), nil)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "release 2135.5.0: json: cannot unmarshal number"), err.Error())
+
+	_, err = decodeReleaseFeed(strings.NewReader(`["2135.4.0"]`), nil)
+	assert.EqualError(t, err, "expected { but found [")
+}
+
+func TestFeedSchemaChangeReported(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", stableReleasesURI,
+		httpmock.NewStringResponder(200, `{"2135.4.0": {"version": "2135.4.0", "release_notes": ["Fix CVE-2019-0001"]}}`))
+	httpmock.RegisterResponder("GET", "http://cve.circl.lu/api/cve/CVE-2019-0001", httpmock.NewStringResponder(200, `{"cvss": 9.8}`))
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.channel = "stable"
+
+	err := repo.GetLatestVersion(context.Background())
+	assert.IsType(t, schemaError{}, err)
+	assert.Contains(t, err.Error(), stableReleasesURI+" does not have the expected format")
+
+	repo.UpdateError(err)
+	_, err = errorRetrievingReleaseInfo(repo)()
+	assert.Contains(t, err.Error(), "does not have the expected format", "the retrieval check reports the schema change")
+
+	fix := retrieveCVE(context.Background(), httpReleaseSource{client: repo.client}, cveURI, "CVE-2019-0001")
+	assert.IsType(t, schemaError{}, fix.err)
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
@@ -10,15 +14,40 @@ import (
 )
 
 type HealthService struct {
-	repo *releaseRepository
+	sync.RWMutex
+	repo       *releaseRepository
+	maxDataAge time.Duration
+	pollLoop   *pollLoop
 }
 
-func NewHealthService(repo *releaseRepository) *HealthService {
+func NewHealthService(repo *releaseRepository, maxDataAge time.Duration) *HealthService {
 	return &HealthService{
-		repo: repo,
+		repo:       repo,
+		maxDataAge: maxDataAge,
 	}
 }
 
+// SetMaxDataAge changes the maximum age of the release information, on a config reload.
+func (service *HealthService) SetMaxDataAge(maxDataAge time.Duration) {
+	service.Lock()
+	defer service.Unlock()
+	service.maxDataAge = maxDataAge
+}
+
+// SetPollLoop adds the liveness of the poll loop to the checks. It must be called before the checks are served.
+func (service *HealthService) SetPollLoop(loop *pollLoop) {
+	service.Lock()
+	defer service.Unlock()
+	service.pollLoop = loop
+}
+
+func (service *HealthService) checkReleaseInfoAge() (string, error) {
+	service.RLock()
+	maxDataAge := service.maxDataAge
+	service.RUnlock()
+	return checkReleaseInfoAge(service.repo, maxDataAge)()
+}
+
 func (service *HealthService) HealthCheckHandler() func(w http.ResponseWriter, r *http.Request) {
 	hc := fthealth.TimedHealthCheck{
 		HealthCheck: fthealth.HealthCheck{
@@ -33,13 +62,22 @@ func (service *HealthService) HealthCheckHandler() func(w http.ResponseWriter, r
 }
 
 func (service *HealthService) checks() []fthealth.Check {
-	return []fthealth.Check{
+	checks := []fthealth.Check{
 		service.releaseInfoRetrievalCheck(),
+		service.releaseInfoStalenessCheck(),
+		service.groupMappedCheck(),
 		service.securityFixesCheck(),
 		service.highSecurityFixesCheck(),
 		service.criticalSecurityFixesCheck(),
 		service.latestVersionCheck(),
 	}
+
+	service.RLock()
+	defer service.RUnlock()
+	if service.pollLoop != nil {
+		checks = append(checks, service.pollLoopCheck(service.pollLoop))
+	}
+	return checks
 }
 
 func (service *HealthService) releaseInfoRetrievalCheck() fthealth.Check {
@@ -53,13 +91,46 @@ func (service *HealthService) releaseInfoRetrievalCheck() fthealth.Check {
 	}
 }
 
+func (service *HealthService) pollLoopCheck(loop *pollLoop) fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "No direct business impact, but the other checks are no longer being updated.",
+		Name:             "CoreOS Release Poll Loop has Stopped",
+		PanicGuide:       "https://dewey.ft.com/coreos-version-checker.html",
+		Severity:         2,
+		TechnicalSummary: "The loop which polls the CoreOS releases has not run a poll when it was due. Check the logs, and restart the checker.",
+		Checker:          loop.Liveness,
+	}
+}
+
+func (service *HealthService) releaseInfoStalenessCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "No direct business impact, but the other checks may be reporting on out of date information.",
+		Name:             "CoreOS Release Information is Stale",
+		PanicGuide:       "https://dewey.ft.com/coreos-version-checker.html",
+		Severity:         2,
+		TechnicalSummary: "The CoreOS release information has not been successfully refreshed recently. Check the logs and the retrieval check for the underlying error.",
+		Checker:          service.checkReleaseInfoAge,
+	}
+}
+
+func (service *HealthService) groupMappedCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "No direct business impact, but the other checks may be comparing against the wrong channel.",
+		Name:             "CoreUpdate Group is not Mapped to a Channel",
+		PanicGuide:       "https://dewey.ft.com/coreos-version-checker.html",
+		Severity:         3,
+		TechnicalSummary: "The GROUP in update.conf is not a standard channel and is not in the groups mapping of the config, so the checker assumes the stable channel. Add the group to the mapping.",
+		Checker:          checkGroupMapped(service.repo),
+	}
+}
+
 func (service *HealthService) highSecurityFixesCheck() fthealth.Check {
 	return fthealth.Check{
 		BusinessImpact:   "It may be possible to compromise our publishing stack using a known security vulnerability.",
 		Name:             "High Risk Security Fix Overdue",
 		PanicGuide:       "https://dewey.ft.com/coreos-version-checker.html",
 		Severity:         1,
-		TechnicalSummary: "The latest version of CoreOS has a HIGH RISK security fix. The FT policy is to upgrade to this version within TWO WEEKS, a deadline which has now been passed!",
+		TechnicalSummary: "The latest version of CoreOS has a HIGH RISK security fix. The FT policy is to upgrade to this version within TWO WEEKS by default, a deadline which has now been passed!",
 		Checker:          checkHighSecurityScore(service.repo),
 	}
 }
@@ -97,16 +168,72 @@ func (service *HealthService) latestVersionCheck() fthealth.Check {
 	}
 }
 
+const (
+	highSecurityFixDeadline     = time.Hour * 336 // 336 hours = 2 weeks
+	criticalSecurityFixDeadline = time.Hour * 48
+	releaseDateFormat           = "2006-01-02 15:04 MST"
+)
+
+// securityPolicy sets how soon a release with HIGH or CRITICAL security fixes must be installed.
+type securityPolicy struct {
+	HighCVSS         float64  `yaml:"highCvss"`
+	CriticalCVSS     float64  `yaml:"criticalCvss"`
+	HighDeadline     duration `yaml:"highDeadline"`
+	CriticalDeadline duration `yaml:"criticalDeadline"`
+}
+
+func defaultSecurityPolicy() securityPolicy {
+	return securityPolicy{
+		HighCVSS:         7,
+		CriticalCVSS:     9,
+		HighDeadline:     duration(highSecurityFixDeadline),
+		CriticalDeadline: duration(criticalSecurityFixDeadline),
+	}
+}
+
+var (
+	policyLock sync.RWMutex
+	policy     = defaultSecurityPolicy()
+)
+
+// currentPolicy returns the security policy of the last applied config.
+func currentPolicy() securityPolicy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return policy
+}
+
+func setSecurityPolicy(p securityPolicy) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	policy = p
+}
+
+// deadlineText describes the policy deadline for the health check outputs, e.g. 2 DAYS.
+func deadlineText(d duration) string {
+	if days := time.Duration(d) / (time.Hour * 24); days > 0 && time.Duration(d)%(time.Hour*24) == 0 {
+		return fmt.Sprintf("%d DAYS", days)
+	}
+	return strings.ToUpper(time.Duration(d).String())
+}
+
+var errNoReleaseInfo = errors.New("Status unknown: the CoreOS release information has not been successfully retrieved yet")
+
 func compareInstalledWithLatest(repo *releaseRepository) func() (string, error) {
 	return func() (string, error) {
 		repo.RLock()
 		defer repo.RUnlock()
 
-		if repo.installedVersion.Version != repo.latestVersion.Version {
-			return "", errors.New("There is a new version of CoreOS available: " + repo.latestVersion.Version)
+		if repo.lastSuccess.IsZero() {
+			return "", errNoReleaseInfo
 		}
 
-		return "", nil
+		output := versionsOutput(repo.state())
+		if newVersionAvailable(repo) {
+			return output, errors.New("There is a new version of CoreOS available: " + repo.latestVersion.Version)
+		}
+
+		return output, nil
 	}
 }
 
@@ -119,17 +246,65 @@ func errorRetrievingReleaseInfo(repo *releaseRepository) func() (string, error)
 	}
 }
 
+func checkReleaseInfoAge(repo *releaseRepository, maxAge time.Duration) func() (string, error) {
+	return func() (string, error) {
+		repo.RLock()
+		defer repo.RUnlock()
+
+		if repo.lastSuccess.IsZero() {
+			return "", errNoReleaseInfo
+		}
+
+		output := "Last successful refresh: " + repo.lastSuccess.UTC().Format(releaseDateFormat) + "."
+		if age := time.Since(repo.lastSuccess); age > maxAge {
+			return output, fmt.Errorf("The CoreOS release information is %v old, which is older than the maximum of %v", age.Round(time.Second), maxAge)
+		}
+
+		// every poll of the offline bundle succeeds, so the information is as old as the bundle
+		if repo.bundle != nil {
+			output += " Offline bundle created: " + repo.bundle.created.UTC().Format(releaseDateFormat) + "."
+			if age := time.Since(repo.bundle.created); age > repo.bundleMaxAge {
+				return output, fmt.Errorf("The offline bundle is %v old, which is older than the maximum of %v", age.Round(time.Second), repo.bundleMaxAge)
+			}
+		}
+
+		return output, nil
+	}
+}
+
+func checkGroupMapped(repo *releaseRepository) func() (string, error) {
+	return func() (string, error) {
+		repo.RLock()
+		defer repo.RUnlock()
+
+		if repo.group == "" {
+			return "", errNoReleaseInfo
+		}
+
+		output := fmt.Sprintf("Group %s follows the %s channel.", repo.group, repo.channel)
+		if !repo.groupMapped {
+			return output, fmt.Errorf("The update group %s is not in the group mapping, assuming the stable channel", repo.group)
+		}
+		return output, nil
+	}
+}
+
 func checkCriticalSecurityScore(repo *releaseRepository) func() (string, error) {
 	return func() (string, error) {
 		repo.RLock()
 		defer repo.RUnlock()
 
-		_, err := compareInstalledWithLatest(repo)()
-		if err != nil && repo.latestVersion.MaxCVSS != nil && *repo.latestVersion.MaxCVSS >= 9 {
-			return "", errors.New("The new version has a CRITICAL security fix! CoreOS must be upgraded within TWO DAYS!")
+		if repo.lastSuccess.IsZero() {
+			return "", errNoReleaseInfo
+		}
+
+		policy := currentPolicy()
+		if newVersionAvailable(repo) && repo.latestVersion.MaxCVSS != nil && *repo.latestVersion.MaxCVSS >= policy.CriticalCVSS {
+			output := securityFixesOutput(repo.state(), policy.CriticalCVSS, time.Duration(policy.CriticalDeadline))
+			return output, fmt.Errorf("The new version has a CRITICAL security fix! CoreOS must be upgraded within %s!", deadlineText(policy.CriticalDeadline))
 		}
 
-		return "", nil
+		return versionsOutput(repo.state()), nil
 	}
 }
 
@@ -138,12 +313,16 @@ func checkAnySecurityFixes(repo *releaseRepository) func() (string, error) {
 		repo.RLock()
 		defer repo.RUnlock()
 
-		_, err := compareInstalledWithLatest(repo)()
-		if err != nil && repo.latestVersion.MaxCVSS != nil && *repo.latestVersion.MaxCVSS > 0 {
-			return "", errors.New("The new version has at least one security fix, and should be prioritised for upgrade.")
+		if repo.lastSuccess.IsZero() {
+			return "", errNoReleaseInfo
 		}
 
-		return "", nil
+		if newVersionAvailable(repo) && repo.latestVersion.MaxCVSS != nil && *repo.latestVersion.MaxCVSS > 0 {
+			output := securityFixesOutput(repo.state(), 0, 0)
+			return output, errors.New("The new version has at least one security fix, and should be prioritised for upgrade.")
+		}
+
+		return versionsOutput(repo.state()), nil
 	}
 }
 
@@ -152,18 +331,90 @@ func checkHighSecurityScore(repo *releaseRepository) func() (string, error) {
 		repo.RLock()
 		defer repo.RUnlock()
 
-		_, err := compareInstalledWithLatest(repo)()
-		if err != nil &&
-			repo.latestVersion.MaxCVSS != nil &&
-			*repo.latestVersion.MaxCVSS >= 7 &&
-			repo.latestVersion.ReleaseDate != nil &&
-			time.Now().After(repo.latestVersion.ReleaseDate.Add(time.Hour*336)) { // 336 hours = 2 weeks
+		if repo.lastSuccess.IsZero() {
+			return "", errNoReleaseInfo
+		}
 
-			return "", errors.New("The new version has a HIGH LEVEL security fix that is over TWO WEEKS old! CoreOS must be upgraded.")
+		policy := currentPolicy()
+		if !newVersionAvailable(repo) || repo.latestVersion.MaxCVSS == nil || *repo.latestVersion.MaxCVSS < policy.HighCVSS {
+			return versionsOutput(repo.state()), nil
 		}
 
-		return "", nil
+		// the deadline is shown while the fix is outstanding, so on-call can see when it falls
+		output := securityFixesOutput(repo.state(), policy.HighCVSS, time.Duration(policy.HighDeadline))
+		if repo.latestVersion.ReleaseDate != nil && time.Now().After(repo.latestVersion.ReleaseDate.Add(time.Duration(policy.HighDeadline))) {
+			return output, fmt.Errorf("The new version has a HIGH LEVEL security fix that is over %s old! CoreOS must be upgraded.", deadlineText(policy.HighDeadline))
+		}
+		return output, nil
+	}
+}
+
+// securityDeadline returns the date by which the release must be installed under the FT policy, if its security
+// fixes impose one.
+func securityDeadline(release coreOSRelease) (time.Time, bool) {
+	if release.MaxCVSS == nil || release.ReleaseDate == nil {
+		return time.Time{}, false
+	}
+
+	policy := currentPolicy()
+	switch {
+	case *release.MaxCVSS >= policy.CriticalCVSS:
+		return release.ReleaseDate.Add(time.Duration(policy.CriticalDeadline)), true
+	case *release.MaxCVSS >= policy.HighCVSS:
+		return release.ReleaseDate.Add(time.Duration(policy.HighDeadline)), true
+	}
+	return time.Time{}, false
+}
+
+// newVersionAvailable expects the caller to hold the repository read lock.
+func newVersionAvailable(repo *releaseRepository) bool {
+	return repo.installedVersion.Version != repo.latestVersion.Version
+}
+
+// versionsOutput describes the installed and latest releases.
+func versionsOutput(state releaseState) string {
+	output := fmt.Sprintf("Installed version: %s. Latest %s version: %s", state.InstalledVersion.Version, state.channelDescription(), state.LatestVersion.Version)
+	if state.LatestVersion.ReleaseDate != nil {
+		output += fmt.Sprintf(", released %s", state.LatestVersion.ReleaseDate.UTC().Format(releaseDateFormat))
+	}
+	return output + "."
+}
+
+// securityFixesOutput lists the security fixes in the latest release with a CVSS score of at least minCVSS, along
+// with the upgrade deadline if one applies.
+func securityFixesOutput(state releaseState, minCVSS float64, deadline time.Duration) string {
+	latest := state.LatestVersion
+	lines := []string{versionsOutput(state)}
+
+	if deadline > 0 {
+		if latest.ReleaseDate != nil {
+			lines = append(lines, "Upgrade deadline: "+latest.ReleaseDate.Add(deadline).UTC().Format(releaseDateFormat)+".")
+		} else {
+			lines = append(lines, "Upgrade deadline: unknown, the release date is not available.")
+		}
+	}
+
+	fixes := make([]cve, 0, len(latest.SecurityFixes))
+	for _, fix := range latest.SecurityFixes {
+		if fix.CVSS >= minCVSS {
+			fixes = append(fixes, fix)
+		}
+	}
+	sort.Slice(fixes, func(i, j int) bool {
+		if fixes[i].CVSS != fixes[j].CVSS {
+			return fixes[i].CVSS > fixes[j].CVSS
+		}
+		return fixes[i].ID < fixes[j].ID
+	})
+
+	for _, fix := range fixes {
+		score := fmt.Sprintf("CVSS %.1f", fix.CVSS)
+		if fix.err != nil {
+			score = "CVSS unknown"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s): %s", fix.ID, score, fmt.Sprintf(cveLinkURI, fix.ID)))
 	}
+	return strings.Join(lines, "\n")
 }
 
 func (service *HealthService) GTG() gtg.Status {
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHealthRepo(installed string, latest coreOSRelease) *releaseRepository {
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.channel = "stable"
+	repo.installedVersion = coreOSRelease{Version: installed}
+	repo.latestVersion = latest
+	repo.lastSuccess = time.Now()
+	return repo
+}
+
+func TestCompareInstalledWithLatestOutput(t *testing.T) {
+	released := time.Date(2019, 6, 25, 20, 35, 59, 0, time.UTC)
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{Version: "2135.5.0", ReleaseDate: &released})
+
+	output, err := compareInstalledWithLatest(repo)()
+	assert.EqualError(t, err, "There is a new version of CoreOS available: 2135.5.0")
+	assert.Equal(t, "Installed version: 2135.4.0. Latest stable version: 2135.5.0, released 2019-06-25 20:35 UTC.", output)
+
+	repo.installedVersion.Version = "2135.5.0"
+	_, err = compareInstalledWithLatest(repo)()
+	assert.NoError(t, err)
+}
+
+func TestCheckCriticalSecurityScoreOutput(t *testing.T) {
+	released := time.Date(2019, 6, 25, 20, 35, 59, 0, time.UTC)
+	maxCVSS := 9.8
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{
+		Version:     "2135.5.0",
+		ReleaseDate: &released,
+		MaxCVSS:     &maxCVSS,
+		SecurityFixes: []cve{
+			{ID: "CVE-2019-0001", CVSS: 5},
+			{ID: "CVE-2019-0002", CVSS: 9.8},
+			{ID: "CVE-2019-0003", CVSS: 9.3},
+		},
+	})
+
+	output, err := checkCriticalSecurityScore(repo)()
+	assert.Error(t, err)
+	assert.Equal(t, "Installed version: 2135.4.0. Latest stable version: 2135.5.0, released 2019-06-25 20:35 UTC.\n"+
+		"Upgrade deadline: 2019-06-27 20:35 UTC.\n"+
+		"CVE-2019-0002 (CVSS 9.8): https://cve.circl.lu/cve/CVE-2019-0002\n"+
+		"CVE-2019-0003 (CVSS 9.3): https://cve.circl.lu/cve/CVE-2019-0003", output)
+}
+
+func TestCheckAnySecurityFixesOutput(t *testing.T) {
+	maxCVSS := 5.0
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{
+		Version: "2135.5.0",
+		MaxCVSS: &maxCVSS,
+		SecurityFixes: []cve{
+			{ID: "CVE-2019-0001", CVSS: 5},
+			{ID: "CVE-2019-0004", err: errors.New("No CVSS found!")},
+		},
+	})
+
+	output, err := checkAnySecurityFixes(repo)()
+	assert.Error(t, err)
+	assert.Equal(t, "Installed version: 2135.4.0. Latest stable version: 2135.5.0.\n"+
+		"CVE-2019-0001 (CVSS 5.0): https://cve.circl.lu/cve/CVE-2019-0001\n"+
+		"CVE-2019-0004 (CVSS unknown): https://cve.circl.lu/cve/CVE-2019-0004", output)
+}
+
+func TestCheckHighSecurityScoreDeadline(t *testing.T) {
+	maxCVSS := 7.5
+	recent := time.Now().Add(-time.Hour * 24)
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{
+		Version:       "2135.5.0",
+		ReleaseDate:   &recent,
+		MaxCVSS:       &maxCVSS,
+		SecurityFixes: []cve{{ID: "CVE-2019-0005", CVSS: 7.5}},
+	})
+
+	output, err := checkHighSecurityScore(repo)()
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Upgrade deadline: "+recent.Add(14*24*time.Hour).UTC().Format(releaseDateFormat)+".", "the deadline is shown before it has passed")
+
+	overdue := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	repo.latestVersion.ReleaseDate = &overdue
+
+	output, err = checkHighSecurityScore(repo)()
+	assert.Error(t, err)
+	assert.Contains(t, output, "Upgrade deadline: 2019-06-15 00:00 UTC.")
+	assert.Contains(t, output, "CVE-2019-0005 (CVSS 7.5)")
+}
+
+func TestChecksUnknownBeforeFirstSuccessfulPoll(t *testing.T) {
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.UpdateError(errors.New("coreos.com is down"))
+
+	for _, check := range []func() (string, error){
+		compareInstalledWithLatest(repo),
+		checkCriticalSecurityScore(repo),
+		checkAnySecurityFixes(repo),
+		checkHighSecurityScore(repo),
+		checkReleaseInfoAge(repo, time.Hour),
+	} {
+		_, err := check()
+		assert.Equal(t, errNoReleaseInfo, err)
+	}
+
+	repo.UpdateError(nil)
+	_, err := checkAnySecurityFixes(repo)()
+	assert.NoError(t, err)
+}
+
+func TestCheckReleaseInfoAge(t *testing.T) {
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{Version: "2135.4.0"})
+
+	_, err := checkReleaseInfoAge(repo, time.Hour)()
+	assert.NoError(t, err)
+
+	repo.lastSuccess = time.Now().Add(-time.Hour * 3)
+	output, err := checkReleaseInfoAge(repo, time.Hour)()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "older than the maximum of 1h0m0s")
+	assert.Contains(t, output, "Last successful refresh:")
+}
+
+func TestCheckReleaseInfoAgeOfOfflineBundle(t *testing.T) {
+	repo := newTestHealthRepo("2135.4.0", coreOSRelease{Version: "2135.4.0"})
+	repo.UseOfflineBundle(&offlineBundle{created: time.Now().Add(-time.Hour * 24)})
+
+	output, err := checkReleaseInfoAge(repo, time.Hour)()
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Offline bundle created:")
+
+	repo.bundle.created = time.Now().Add(-time.Hour * 24 * 8)
+	_, err = checkReleaseInfoAge(repo, time.Hour)()
+	assert.Error(t, err, "the polls of the bundle succeed, but the bundle is older than the bundleMaxAge")
+	assert.Contains(t, err.Error(), "The offline bundle is 192h0m0s old, which is older than the maximum of 168h0m0s")
+}
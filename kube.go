@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesClient is the subset of the Kubernetes API used by the checker, so it can be faked in tests.
+type kubernetesClient interface {
+	Endpoints(ctx context.Context, namespace string, service string) (kubeEndpoints, error)
+	Nodes(ctx context.Context) ([]kubeNode, error)
+	PatchNode(ctx context.Context, name string, patch interface{}) error
+	CreateEvent(ctx context.Context, namespace string, event kubeEvent) error
+	CreateNodeOSStatus(ctx context.Context, status nodeOSStatus) error
+	PatchNodeOSStatus(ctx context.Context, name string, patch interface{}) error
+}
+
+// kubeRESTClient is a minimal client for the Kubernetes API, using the in-cluster service account credentials.
+type kubeRESTClient struct {
+	client    *http.Client
+	host      string
+	token     string
+	namespace string
+}
+
+func newInClusterKubeRESTClient() (*kubeRESTClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("Not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountPath + "/token")
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := ioutil.ReadFile(serviceAccountPath + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("Failed to parse the service account CA certificate")
+	}
+
+	return &kubeRESTClient{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		host:      "https://" + net.JoinHostPort(host, port),
+		token:     string(bytes.TrimSpace(token)),
+		namespace: string(bytes.TrimSpace(namespace)),
+	}, nil
+}
+
+// do sends a request to the API server, encoding in as the JSON body if it is not nil, and decoding the response
+// into out if it is not nil.
+func (k *kubeRESTClient) do(ctx context.Context, method string, path string, contentType string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, k.host+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &kubeAPIError{StatusCode: resp.StatusCode, Message: string(msg), Method: method, Path: path}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type kubeAPIError struct {
+	StatusCode int
+	Message    string
+	Method     string
+	Path       string
+}
+
+func (e *kubeAPIError) Error() string {
+	return fmt.Sprintf("Kubernetes API %s %s failed with status %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+type kubeEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP       string `json:"ip"`
+			NodeName string `json:"nodeName"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// Endpoints returns the ready endpoints of the given service in the given namespace, or the client's own namespace
+// if empty.
+func (k *kubeRESTClient) Endpoints(ctx context.Context, namespace string, service string) (kubeEndpoints, error) {
+	if namespace == "" {
+		namespace = k.namespace
+	}
+
+	var endpoints kubeEndpoints
+	err := k.do(ctx, "GET", fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", namespace, service), "", nil, &endpoints)
+	return endpoints, err
+}
+
+type kubeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+type kubeNode struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		Unschedulable bool        `json:"unschedulable,omitempty"`
+		Taints        []kubeTaint `json:"taints,omitempty"`
+	} `json:"spec"`
+}
+
+// Nodes returns all the nodes in the cluster.
+func (k *kubeRESTClient) Nodes(ctx context.Context) ([]kubeNode, error) {
+	var list struct {
+		Items []kubeNode `json:"items"`
+	}
+	err := k.do(ctx, "GET", "/api/v1/nodes", "", nil, &list)
+	return list.Items, err
+}
+
+// PatchNode applies a JSON merge patch to the given node.
+func (k *kubeRESTClient) PatchNode(ctx context.Context, name string, patch interface{}) error {
+	return k.do(ctx, "PATCH", "/api/v1/nodes/"+name, "application/merge-patch+json", patch, nil)
+}
+
+type kubeObjectMeta struct {
+	Name            string            `json:"name,omitempty"`
+	GenerateName    string            `json:"generateName,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+type kubeObjectReference struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type kubeEvent struct {
+	Metadata       kubeObjectMeta      `json:"metadata"`
+	InvolvedObject kubeObjectReference `json:"involvedObject"`
+	Reason         string              `json:"reason"`
+	Message        string              `json:"message"`
+	Type           string              `json:"type"`
+	Source         struct {
+		Component string `json:"component"`
+		Host      string `json:"host,omitempty"`
+	} `json:"source"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+	Count          int       `json:"count"`
+}
+
+// CreateEvent creates the event in the given namespace.
+func (k *kubeRESTClient) CreateEvent(ctx context.Context, namespace string, event kubeEvent) error {
+	return k.do(ctx, "POST", fmt.Sprintf("/api/v1/namespaces/%s/events", namespace), "application/json", event, nil)
+}
+
+// CreateNodeOSStatus creates the cluster scoped NodeOSStatus custom resource.
+func (k *kubeRESTClient) CreateNodeOSStatus(ctx context.Context, status nodeOSStatus) error {
+	return k.do(ctx, "POST", nodeOSStatusPath, "application/json", status, nil)
+}
+
+// PatchNodeOSStatus applies a JSON merge patch to the given NodeOSStatus custom resource.
+func (k *kubeRESTClient) PatchNodeOSStatus(ctx context.Context, name string, patch interface{}) error {
+	return k.do(ctx, "PATCH", nodeOSStatusPath+"/"+name, "application/merge-patch+json", patch, nil)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKubernetesClient records the calls made to the Kubernetes API.
+type fakeKubernetesClient struct {
+	sync.Mutex
+	endpoints   kubeEndpoints
+	nodes       []kubeNode
+	nodePatches []interface{}
+	events      []kubeEvent
+	osStatuses  map[string]nodeOSStatus
+	err         error
+}
+
+func (f *fakeKubernetesClient) Endpoints(_ context.Context, namespace string, service string) (kubeEndpoints, error) {
+	return f.endpoints, f.err
+}
+
+func (f *fakeKubernetesClient) Nodes(_ context.Context) ([]kubeNode, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.nodes, f.err
+}
+
+func (f *fakeKubernetesClient) PatchNode(_ context.Context, name string, patch interface{}) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.nodePatches = append(f.nodePatches, patch)
+	return nil
+}
+
+func (f *fakeKubernetesClient) CreateEvent(_ context.Context, namespace string, event kubeEvent) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	event.Metadata.Namespace = namespace
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakeKubernetesClient) CreateNodeOSStatus(_ context.Context, status nodeOSStatus) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if f.osStatuses == nil {
+		f.osStatuses = make(map[string]nodeOSStatus)
+	}
+	if _, ok := f.osStatuses[status.Metadata.Name]; ok {
+		return &kubeAPIError{StatusCode: http.StatusConflict, Method: "POST", Path: nodeOSStatusPath}
+	}
+	f.osStatuses[status.Metadata.Name] = status
+	return nil
+}
+
+func (f *fakeKubernetesClient) PatchNodeOSStatus(_ context.Context, name string, patch interface{}) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	existing, ok := f.osStatuses[name]
+	if !ok {
+		return &kubeAPIError{StatusCode: http.StatusNotFound, Method: "PATCH", Path: nodeOSStatusPath + "/" + name}
+	}
+
+	// round trip the patch through JSON to apply it the way a merge patch of the status would be
+	b, _ := json.Marshal(patch)
+	var applied struct {
+		Status nodeOSStatusStatus `json:"status"`
+	}
+	json.Unmarshal(b, &applied)
+	existing.Status = applied.Status
+	f.osStatuses[name] = existing
+	return nil
+}
+
+func TestKubeRESTClientPatchNode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PATCH", r.Method)
+		assert.Equal(t, "/api/v1/nodes/node-1", r.URL.Path)
+		assert.Equal(t, "application/merge-patch+json", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.JSONEq(t, `{"metadata": {"labels": {"a": "b"}}}`, string(body))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := &kubeRESTClient{client: server.Client(), host: server.URL, token: "token"}
+	err := client.PatchNode(context.Background(), "node-1", map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]string{"a": "b"}}})
+	assert.NoError(t, err)
+}
+
+func TestKubeRESTClientError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"message": "nodes is forbidden"})
+	}))
+	defer server.Close()
+
+	client := &kubeRESTClient{client: server.Client(), host: server.URL, token: "token"}
+	err := client.PatchNode(context.Background(), "node-1", map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, err.(*kubeAPIError).StatusCode)
+}
+
+func TestKubeRESTClientCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("The request should not be sent once the context is cancelled")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client := &kubeRESTClient{client: server.Client(), host: server.URL, token: "token"}
+	_, err := client.Nodes(ctx)
+	assert.Error(t, err)
+}
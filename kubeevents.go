@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// node events are created in the default namespace, as the kubelet does
+const nodeEventsNamespace = "default"
+
+var kubeEventReasons = map[eventType]struct {
+	reason    string
+	eventType string
+}{
+	eventNewVersion:          {"CoreOSUpdateAvailable", "Normal"},
+	eventSecurityFix:         {"CoreOSSecurityFixAvailable", "Warning"},
+	eventDeadlineApproaching: {"CoreOSUpgradeDeadlineApproaching", "Warning"},
+	eventDeadlinePassed:      {"CoreOSUpgradeDeadlinePassed", "Warning"},
+	eventResolved:            {"CoreOSUpgraded", "Normal"},
+}
+
+// kubeEventNotifier records events against the Node the checker is running on, so they show up in
+// `kubectl describe node`.
+type kubeEventNotifier struct {
+	client kubernetesClient
+	node   string
+}
+
+func newKubeEventNotifier(client kubernetesClient, node string) *kubeEventNotifier {
+	return &kubeEventNotifier{client: client, node: node}
+}
+
+func (k *kubeEventNotifier) Name() string {
+	return "kubernetes events"
+}
+
+// recordsEveryEvent records an Event for each condition, so the history of the node shows every one of them.
+func (k *kubeEventNotifier) recordsEveryEvent() bool {
+	return true
+}
+
+func (k *kubeEventNotifier) Notify(ctx context.Context, e event) error {
+	reason, ok := kubeEventReasons[e.Type]
+	if !ok {
+		return nil
+	}
+
+	ke := kubeEvent{
+		Metadata: kubeObjectMeta{GenerateName: k.node + "."},
+		// kubectl describe node matches events on the node name as UID, as the kubelet reports them
+		InvolvedObject: kubeObjectReference{Kind: "Node", Name: k.node, UID: k.node},
+		Reason:         reason.reason,
+		Message:        e.Summary(),
+		Type:           reason.eventType,
+		FirstTimestamp: e.Time.UTC().Truncate(time.Second),
+		LastTimestamp:  e.Time.UTC().Truncate(time.Second),
+		Count:          1,
+	}
+	ke.Source.Component = "coreos-version-checker"
+	ke.Source.Host = k.node
+
+	return k.client.CreateEvent(ctx, nodeEventsNamespace, ke)
+}
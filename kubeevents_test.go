@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubeEventNotifier(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeKubernetesClient{}
+	w := newEventWatcher("node-1", time.Hour*24, newKubeEventNotifier(fake, "node-1"))
+	w.now = func() time.Time { return now }
+
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour*72)))
+	w.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", 9.8, now.Add(-time.Hour*72)))
+	assert.Len(t, fake.events, 4)
+
+	var reasons []string
+	for _, e := range fake.events {
+		reasons = append(reasons, e.Reason)
+	}
+	assert.Equal(t, []string{"CoreOSUpdateAvailable", "CoreOSSecurityFixAvailable", "CoreOSUpgradeDeadlinePassed", "CoreOSUpgraded"}, reasons, "an Event is recorded for every condition")
+
+	passed := fake.events[2]
+	assert.Equal(t, "default", passed.Metadata.Namespace)
+	assert.Equal(t, "node-1.", passed.Metadata.GenerateName)
+	assert.Equal(t, kubeObjectReference{Kind: "Node", Name: "node-1", UID: "node-1"}, passed.InvolvedObject)
+	assert.Equal(t, "CoreOSUpgradeDeadlinePassed", passed.Reason)
+	assert.Equal(t, "Warning", passed.Type)
+	assert.Equal(t, "node-1 has passed the deadline of 2019-06-30 00:00 UTC to upgrade to CoreOS 2135.5.0!", passed.Message)
+	assert.Equal(t, "coreos-version-checker", passed.Source.Component)
+	assert.Equal(t, now, passed.FirstTimestamp)
+
+	upgraded := fake.events[3]
+	assert.Equal(t, "CoreOSUpgraded", upgraded.Reason)
+	assert.Equal(t, "Normal", upgraded.Type)
+}
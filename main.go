@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	status "github.com/Financial-Times/service-status-go/httphandlers"
@@ -12,75 +18,577 @@ import (
 )
 
 var (
+	configPath            *string
 	coreOSUpdateConfPath  *string
 	coreOSReleaseConfPath *string
+	nodeName              *string
+	slackWebhookURL       *string
+	webhooksConfigPath    *string
+	notifiedStateFile     *string
+	alertmanagerURL       *string
+	alertmanagerStateFile *string
+	pagerDutyRoutingKey   *string
+	publishNodeStatus     *bool
+	kubernetesEvents      *bool
+	publishNodeOSStatus   *bool
+	offlineBundlePath     *string
+	offlineBundleKey      *string
+	refreshMinInterval    = duration(time.Minute)
+	maxDataAge            = duration(time.Hour * 2)
+	deadlineWarning       = duration(time.Hour * 24)
+	alertmanagerAlertTTL  = duration(time.Minute * 90)
+
+	updateConfSetByUser         bool
+	releaseConfSetByUser        bool
+	refreshMinIntervalSetByUser bool
+	maxDataAgeSetByUser         bool
 )
 
 func main() {
 	app := cli.App("coreos-version-checker", "Checks for new CoreOS upgrades, and reports on the CVE severity score.")
 
+	configPath = app.String(cli.StringOpt{
+		Name:   "config",
+		Value:  "",
+		Desc:   "The location of a YAML config file, which is reloaded when it changes or on SIGHUP. Environment variables and command line options override its settings.",
+		EnvVar: "CONFIG_FILE",
+	})
+
 	coreOSUpdateConfPath = app.String(cli.StringOpt{
-		Name:   "update-conf",
-		Value:  "/etc/coreos/update.conf",
-		Desc:   "The location of the CoreOS update.conf file.",
-		EnvVar: "UPDATE_CONF",
+		Name:      "update-conf",
+		Value:     "/etc/coreos/update.conf",
+		Desc:      "The location of the CoreOS update.conf file.",
+		EnvVar:    "UPDATE_CONF",
+		SetByUser: &updateConfSetByUser,
 	})
 
 	coreOSReleaseConfPath = app.String(cli.StringOpt{
-		Name:   "release-conf",
-		Value:  "/usr/share/coreos/release",
-		Desc:   "The location of the CoreOS release file.",
-		EnvVar: "RELEASE_CONF",
+		Name:      "release-conf",
+		Value:     "/usr/share/coreos/release",
+		Desc:      "The location of the CoreOS release file.",
+		EnvVar:    "RELEASE_CONF",
+		SetByUser: &releaseConfSetByUser,
+	})
+
+	app.Var(cli.VarOpt{
+		Name:      "refresh-min-interval",
+		Value:     &refreshMinInterval,
+		Desc:      "The minimum time between two polls triggered through the /refresh endpoint.",
+		EnvVar:    "REFRESH_MIN_INTERVAL",
+		SetByUser: &refreshMinIntervalSetByUser,
+	})
+
+	app.Var(cli.VarOpt{
+		Name:      "max-data-age",
+		Value:     &maxDataAge,
+		Desc:      "The maximum age of the last successful poll before the release information is reported as stale.",
+		EnvVar:    "MAX_DATA_AGE",
+		SetByUser: &maxDataAgeSetByUser,
+	})
+
+	hostname, _ := os.Hostname()
+	nodeName = app.String(cli.StringOpt{
+		Name:   "node-name",
+		Value:  hostname,
+		Desc:   "The name of the node being checked, as reported in notifications.",
+		EnvVar: "NODE_NAME",
+	})
+
+	slackWebhookURL = app.String(cli.StringOpt{
+		Name:   "slack-webhook-url",
+		Value:  "",
+		Desc:   "A Slack incoming webhook to notify when the upgrade status changes. Notifications are disabled if empty.",
+		EnvVar: "SLACK_WEBHOOK_URL",
+	})
+
+	webhooksConfigPath = app.String(cli.StringOpt{
+		Name:   "webhooks-config",
+		Value:  "",
+		Desc:   "The location of a JSON file listing the webhooks to notify when the upgrade status changes.",
+		EnvVar: "WEBHOOKS_CONFIG",
+	})
+
+	notifiedStateFile = app.String(cli.StringOpt{
+		Name:   "notified-state-file",
+		Value:  "",
+		Desc:   "A file on the host to keep the notified conditions in across restarts, so the upgrade is notified after the reboot. The conditions are only kept in memory if empty.",
+		EnvVar: "NOTIFIED_STATE_FILE",
+	})
+
+	app.Var(cli.VarOpt{
+		Name:   "deadline-warning",
+		Value:  &deadlineWarning,
+		Desc:   "How long before a security fix upgrade deadline to notify that the deadline is approaching.",
+		EnvVar: "DEADLINE_WARNING",
+	})
+
+	alertmanagerURL = app.String(cli.StringOpt{
+		Name:   "alertmanager-url",
+		Value:  "",
+		Desc:   "The base URL of a Prometheus Alertmanager to push alerts to after every poll. Alerts are not pushed if empty.",
+		EnvVar: "ALERTMANAGER_URL",
+	})
+
+	alertmanagerStateFile = app.String(cli.StringOpt{
+		Name:   "alertmanager-state-file",
+		Value:  "",
+		Desc:   "A file on the host to keep the firing alerts in across restarts, so they are resolved after the reboot into the upgrade. The alerts are only kept in memory if empty.",
+		EnvVar: "ALERTMANAGER_STATE_FILE",
+	})
+
+	app.Var(cli.VarOpt{
+		Name:   "alertmanager-alert-ttl",
+		Value:  &alertmanagerAlertTTL,
+		Desc:   "How long a pushed alert stays firing without being refreshed by a later poll. Should be longer than the poll interval.",
+		EnvVar: "ALERTMANAGER_ALERT_TTL",
+	})
+
+	pagerDutyRoutingKey = app.String(cli.StringOpt{
+		Name:      "pagerduty-routing-key",
+		Value:     "",
+		Desc:      "The PagerDuty Events API v2 routing key to page with for critical and overdue high security fixes. Paging is disabled if empty.",
+		EnvVar:    "PAGERDUTY_ROUTING_KEY",
+		HideValue: true,
+	})
+
+	publishNodeStatus = app.Bool(cli.BoolOpt{
+		Name:   "publish-node-status",
+		Value:  false,
+		Desc:   "Publish the CoreOS status as labels and annotations on the Kubernetes node, using the in-cluster credentials.",
+		EnvVar: "PUBLISH_NODE_STATUS",
+	})
+
+	kubernetesEvents = app.Bool(cli.BoolOpt{
+		Name:   "kubernetes-events",
+		Value:  false,
+		Desc:   "Record Kubernetes events against the node when the upgrade status changes, using the in-cluster credentials.",
+		EnvVar: "KUBERNETES_EVENTS",
+	})
+
+	publishNodeOSStatus = app.Bool(cli.BoolOpt{
+		Name:   "publish-nodeosstatus",
+		Value:  false,
+		Desc:   "Create and update a NodeOSStatus custom resource for the node after every poll, using the in-cluster credentials.",
+		EnvVar: "PUBLISH_NODEOSSTATUS",
+	})
+
+	offlineBundlePath = app.String(cli.StringOpt{
+		Name:   "offline-bundle",
+		Desc:   "Read the release feeds and CVEs from a bundle written by the export command, instead of coreos.com and cve.circl.lu.",
+		EnvVar: "OFFLINE_BUNDLE",
+	})
+	offlineBundleKey = app.String(cli.StringOpt{
+		Name:   "offline-bundle-key",
+		Desc:   "The base64 Ed25519 public key the offline bundle is verified with, as logged by the export command.",
+		EnvVar: "OFFLINE_BUNDLE_KEY",
 	})
 
 	app.Action = func() {
 		log.SetFormatter(&log.JSONFormatter{})
-		log.WithField("update-conf", *coreOSUpdateConfPath).WithField("release-conf", *coreOSReleaseConfPath).Info("Started with provided config.")
 
-		client := &http.Client{Timeout: 1500 * time.Millisecond}
-		repo := newReleaseRepository(client, *coreOSReleaseConfPath, *coreOSUpdateConfPath)
-		healthService := NewHealthService(repo)
-		go startPoll(time.Minute*30, repo)
+		cfg, err := loadCheckerConfig()
+		if err != nil {
+			log.WithError(err).Fatal("Failed to load the config.")
+		}
+		log.WithField("update-conf", cfg.UpdateConf).WithField("release-conf", cfg.ReleaseConf).WithField("config", *configPath).Info("Started with provided config.")
+
+		client := &http.Client{Timeout: time.Duration(cfg.HTTP.Timeout)}
+		repo, err := newCheckerRepository(client, cfg)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to load the offline bundle.")
+		}
+		healthService := NewHealthService(repo, time.Duration(cfg.MaxDataAge))
+		// cancelled on shutdown, which stops the polls and any requests in flight
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		refresher := newRefresher(ctx, repo, func(ctx context.Context) error { return pollCoreOSReleases(ctx, repo) }, time.Duration(cfg.RefreshMinInterval))
+		setSecurityPolicy(cfg.Policy)
+
+		// the settings are swapped in place on a reload, so the HTTP server keeps running
+		cfgWatcher := newConfigWatcher(*configPath, cfg, loadCheckerConfig, func(reloaded config) {
+			if reloaded.Port != cfg.Port {
+				log.WithField("port", reloaded.Port).Warn("The port cannot be changed without a restart, the server stays on the current port.")
+			}
+			repo.Configure(reloaded)
+			healthService.SetMaxDataAge(time.Duration(reloaded.MaxDataAge))
+			refresher.SetMinInterval(time.Duration(reloaded.RefreshMinInterval))
+			setSecurityPolicy(reloaded.Policy)
+		})
+		if *configPath != "" {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGHUP)
+			go cfgWatcher.Watch(ctx, configWatchInterval, signals)
+		}
+
+		var kube kubernetesClient
+		kubeClient := func() kubernetesClient {
+			if kube == nil {
+				client, err := newInClusterKubeRESTClient()
+				if err != nil {
+					log.WithError(err).Fatal("Failed to create the Kubernetes client.")
+				}
+				kube = client
+			}
+			return kube
+		}
+
+		var notifiers []notifier
+		if *slackWebhookURL != "" {
+			notifiers = append(notifiers, newSlackNotifier(newRetryableClient(client), *slackWebhookURL))
+		}
+		if *webhooksConfigPath != "" {
+			configs, err := loadWebhookConfigs(*webhooksConfigPath)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to load the webhooks config.")
+			}
+			for _, config := range configs {
+				webhook, err := newWebhookNotifier(newRetryableClient(client), config)
+				if err != nil {
+					log.WithError(err).Fatal("Invalid webhook config.")
+				}
+				notifiers = append(notifiers, webhook)
+			}
+		}
+		if *kubernetesEvents {
+			notifiers = append(notifiers, newKubeEventNotifier(kubeClient(), *nodeName))
+		}
+		if len(notifiers) > 0 {
+			events := newEventWatcher(*nodeName, time.Duration(deadlineWarning), notifiers...)
+			if *notifiedStateFile != "" {
+				if err := events.PersistTo(*notifiedStateFile); err != nil {
+					log.WithError(err).WithField("file", *notifiedStateFile).Warn("Failed to load the notified conditions, they may be notified again.")
+				}
+			}
+			refresher.OnPoll(events.Observe)
+		}
+		if *alertmanagerURL != "" {
+			pusher := newAlertmanagerPusher(newRetryableClient(client), *alertmanagerURL, *nodeName, time.Duration(alertmanagerAlertTTL))
+			if *alertmanagerStateFile != "" {
+				if err := pusher.PersistTo(*alertmanagerStateFile); err != nil {
+					log.WithError(err).WithField("file", *alertmanagerStateFile).Warn("Failed to load the firing alerts, they will expire rather than be resolved.")
+				}
+			}
+			refresher.OnPoll(pusher.Observe)
+		}
+		if *pagerDutyRoutingKey != "" {
+			pagerDuty := newPagerDutyNotifier(newRetryableClient(client), *pagerDutyRoutingKey, *nodeName)
+			refresher.OnPoll(pagerDuty.Observe)
+		}
+		if *publishNodeStatus {
+			publisher := newNodeStatusPublisher(kubeClient(), *nodeName)
+			refresher.OnPoll(publisher.Observe)
+		}
+		if *publishNodeOSStatus {
+			publisher := newNodeOSStatusPublisher(kubeClient(), *nodeName)
+			refresher.OnPoll(publisher.Observe)
+		}
+
+		// a poll is overdue once it has run for longer than the poll timeout, with a minute for the observers
+		loop := newPollLoop(refresher, func() time.Duration { return time.Duration(cfgWatcher.Current().PollInterval) }, func() time.Duration {
+			return time.Duration(cfgWatcher.Current().PollTimeout) + time.Minute
+		})
+		healthService.SetPollLoop(loop)
+		go loop.Run(ctx)
 
 		mux := mux.NewRouter()
 		mux.HandleFunc("/__health", healthService.HealthCheckHandler()).Methods("GET")
 		mux.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(healthService.GTG))
-		log.Printf("Starting http server on 8080\n")
-		err := http.ListenAndServe(":8080", mux)
+		mux.HandleFunc("/refresh", refresher.RefreshHandler()).Methods("POST")
+		mux.HandleFunc("/refresh/{id}", refresher.JobHandler()).Methods("GET")
+		mux.HandleFunc(statePath, nodeStateHandler(*nodeName, repo)).Methods("GET")
+		log.Printf("Starting http server on %d\n", cfg.Port)
+		server := &http.Server{Addr: ":" + strconv.Itoa(cfg.Port), Handler: mux}
+		serveUntilTerminated(server, cancel, func() time.Duration { return time.Duration(cfgWatcher.Current().DrainTimeout) })
+	}
+
+	app.Command("check", "Polls the releases once, evaluates the health checks and exits with 0 (ok), 1 (warning), 2 (critical) or 3 (unknown).", checkCommand)
+	app.Command("diff", "Prints the release notes, security fixes and package changes between two releases of a channel.", diffCommand)
+	app.Command("export", "Snapshots the release feeds and CVEs into a signed bundle for --offline-bundle.", exportCommand)
+	app.Command("aggregate", "Collects the state of the checker on every node into a fleet view.", aggregateCommand)
+
+	app.Run(os.Args)
+}
+
+func aggregateCommand(cmd *cli.Cmd) {
+	peers := cmd.Strings(cli.StringsOpt{
+		Name:   "peers",
+		Value:  []string{},
+		Desc:   "A static list of checker base URLs to aggregate, e.g. http://10.2.3.4:8080.",
+		EnvVar: "PEERS",
+	})
+
+	peersSRV := cmd.String(cli.StringOpt{
+		Name:   "peers-srv",
+		Value:  "",
+		Desc:   "A DNS SRV record to discover the checkers from, e.g. _http._tcp.coreos-version-checker.default.svc.cluster.local.",
+		EnvVar: "PEERS_SRV",
+	})
+
+	peersService := cmd.String(cli.StringOpt{
+		Name:   "peers-kubernetes-service",
+		Value:  "",
+		Desc:   "A Kubernetes service to discover the checkers from the endpoints of, as name or namespace/name.",
+		EnvVar: "PEERS_KUBERNETES_SERVICE",
+	})
+
+	port := cmd.Int(cli.IntOpt{
+		Name:   "port",
+		Value:  8080,
+		Desc:   "The port to serve the fleet view on.",
+		EnvVar: "PORT",
+	})
+
+	interval := duration(time.Minute * 5)
+	cmd.Var(cli.VarOpt{
+		Name:   "interval",
+		Value:  &interval,
+		Desc:   "How often to collect the state of the checkers.",
+		EnvVar: "AGGREGATE_INTERVAL",
+	})
+
+	remediation := cmd.String(cli.StringOpt{
+		Name:   "remediation",
+		Value:  "",
+		Desc:   "Cordon or taint the nodes which pass the critical security fix deadline, using the in-cluster credentials. One of cordon or taint, disabled if empty.",
+		EnvVar: "REMEDIATION",
+	})
+
+	remediationTaintEffect := cmd.String(cli.StringOpt{
+		Name:   "remediation-taint-effect",
+		Value:  "NoSchedule",
+		Desc:   "The effect of the taint added by the taint remediation, one of NoSchedule, PreferNoSchedule or NoExecute.",
+		EnvVar: "REMEDIATION_TAINT_EFFECT",
+	})
+
+	maxUnavailable := cmd.Int(cli.IntOpt{
+		Name:   "max-unavailable",
+		Value:  1,
+		Desc:   "The maximum number of nodes which may be unschedulable across the cluster before the remediation stops cordoning or tainting more.",
+		EnvVar: "MAX_UNAVAILABLE",
+	})
+
+	smtpAddr := cmd.String(cli.StringOpt{
+		Name:   "smtp-addr",
+		Value:  "",
+		Desc:   "The host:port of the SMTP server to send the fleet upgrade digest email through. The digest is disabled if empty.",
+		EnvVar: "SMTP_ADDR",
+	})
+
+	smtpUsername := cmd.String(cli.StringOpt{
+		Name:   "smtp-username",
+		Value:  "",
+		Desc:   "The username to authenticate with the SMTP server, if required.",
+		EnvVar: "SMTP_USERNAME",
+	})
+
+	smtpPassword := cmd.String(cli.StringOpt{
+		Name:      "smtp-password",
+		Value:     "",
+		Desc:      "The password to authenticate with the SMTP server, if required.",
+		EnvVar:    "SMTP_PASSWORD",
+		HideValue: true,
+	})
+
+	emailFrom := cmd.String(cli.StringOpt{
+		Name:   "email-from",
+		Value:  "coreos-version-checker@ft.com",
+		Desc:   "The sender address of the fleet upgrade digest email.",
+		EnvVar: "EMAIL_FROM",
+	})
+
+	emailTo := cmd.Strings(cli.StringsOpt{
+		Name:   "email-to",
+		Value:  []string{},
+		Desc:   "The recipients of the fleet upgrade digest email.",
+		EnvVar: "EMAIL_TO",
+	})
+
+	emailDigestInterval := duration(time.Hour * 24)
+	cmd.Var(cli.VarOpt{
+		Name:   "email-digest-interval",
+		Value:  &emailDigestInterval,
+		Desc:   "How often to send the fleet upgrade digest email, e.g. 24h for daily or 168h for weekly.",
+		EnvVar: "EMAIL_DIGEST_INTERVAL",
+	})
+
+	dryRun := cmd.Bool(cli.BoolOpt{
+		Name:   "dry-run",
+		Value:  false,
+		Desc:   "Only log and annotate the nodes with the remediation which would have been applied.",
+		EnvVar: "DRY_RUN",
+	})
+
+	cmd.Action = func() {
+		log.SetFormatter(&log.JSONFormatter{})
+
+		// the security policy decides the deadlines of the fleet view, the digest and the remediation
+		cfg, err := loadCheckerConfig()
 		if err != nil {
+			log.WithError(err).Fatal("Failed to load the config.")
+		}
+		setSecurityPolicy(cfg.Policy)
+		// cancelled on shutdown, which stops the collections, the digest and the config watcher
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cfgWatcher := newConfigWatcher(*configPath, cfg, loadCheckerConfig, func(reloaded config) {
+			setSecurityPolicy(reloaded.Policy)
+		})
+		if *configPath != "" {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGHUP)
+			go cfgWatcher.Watch(ctx, configWatchInterval, signals)
+		}
+
+		var kube kubernetesClient
+		kubeClient := func() kubernetesClient {
+			if kube == nil {
+				client, err := newInClusterKubeRESTClient()
+				if err != nil {
+					log.WithError(err).Fatal("Failed to create the Kubernetes client.")
+				}
+				kube = client
+			}
+			return kube
+		}
+
+		var discoverer peerDiscoverer
+		switch {
+		case len(*peers) > 0:
+			discoverer = staticPeers(*peers)
+		case *peersSRV != "":
+			discoverer = newSRVPeers(*peersSRV)
+		case *peersService != "":
+			k8s := &kubernetesPeers{client: kubeClient(), service: *peersService}
+			if i := strings.Index(*peersService, "/"); i >= 0 {
+				k8s.namespace, k8s.service = (*peersService)[:i], (*peersService)[i+1:]
+			}
+			discoverer = k8s
+		default:
+			log.Fatal("One of --peers, --peers-srv or --peers-kubernetes-service must be provided.")
+		}
+
+		agg := newAggregator(discoverer, &http.Client{Timeout: 5 * time.Second})
+		if *remediation != "" {
+			controller, err := newRemediationController(kubeClient(), *remediation, *remediationTaintEffect, *maxUnavailable, *dryRun)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid remediation config.")
+			}
+			agg.OnCollect(controller.Reconcile)
+		}
+		if *smtpAddr != "" && len(*emailTo) > 0 {
+			config := smtpConfig{Addr: *smtpAddr, From: *emailFrom, To: *emailTo, Username: *smtpUsername, Password: *smtpPassword}
+			digest := newEmailDigest(config, time.Duration(emailDigestInterval))
+			agg.OnCollect(digest.Observe)
+			go digest.Start(ctx)
+		}
+		go agg.Start(ctx, time.Duration(interval))
+
+		mux := mux.NewRouter()
+		mux.HandleFunc("/__health", agg.HealthCheckHandler()).Methods("GET")
+		mux.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(agg.GTG))
+		mux.HandleFunc("/fleet", agg.FleetHandler()).Methods("GET")
+		mux.HandleFunc("/fleet/nodes", agg.NodesHandler()).Methods("GET")
+		log.Printf("Starting aggregator http server on %d\n", *port)
+		server := &http.Server{Addr: ":" + strconv.Itoa(*port), Handler: mux}
+		serveUntilTerminated(server, cancel, func() time.Duration { return time.Duration(cfgWatcher.Current().DrainTimeout) })
+	}
+}
+
+// serveUntilTerminated serves HTTP until SIGTERM or an interrupt, then lets the open requests finish within the drain
+// timeout before it cancels the background work.
+func serveUntilTerminated(server *http.Server, cancel context.CancelFunc, drainTimeout func() time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			panic(err)
 		}
+	}()
+
+	sig := <-signals
+	log.WithField("signal", sig.String()).Info("Shutting down.")
+	drain(server, cancel, drainTimeout())
+}
+
+// drain shuts the server down, then cancels the background work. The open requests, such as a synchronous refresh,
+// may finish before the poll they wait for is cancelled.
+func drain(server *http.Server, cancel context.CancelFunc, timeout time.Duration) {
+	ctx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).Warn("The open requests did not finish before the drain timeout.")
 	}
+	cancel()
+}
 
-	app.Run(os.Args)
+// loadCheckerConfig loads the config file and environment overrides, then applies the command line options which
+// were explicitly given.
+func loadCheckerConfig() (config, error) {
+	cfg, err := loadConfig(*configPath, os.LookupEnv)
+	if err != nil {
+		return cfg, err
+	}
+
+	if updateConfSetByUser {
+		cfg.UpdateConf = *coreOSUpdateConfPath
+	}
+	if releaseConfSetByUser {
+		cfg.ReleaseConf = *coreOSReleaseConfPath
+	}
+	if refreshMinIntervalSetByUser {
+		cfg.RefreshMinInterval = refreshMinInterval
+	}
+	if maxDataAgeSetByUser {
+		cfg.MaxDataAge = maxDataAge
+	}
+	return cfg, cfg.validate()
 }
 
-func startPoll(interval time.Duration, repo *releaseRepository) {
-	err := pollCoreOSReleases(repo)
-	repo.UpdateError(err)
+// newCheckerRepository creates the release repository for the config, serving the releases from the offline bundle
+// if one was given.
+func newCheckerRepository(client *http.Client, cfg config) (*releaseRepository, error) {
+	repo := newReleaseRepository(client, cfg.ReleaseConf, cfg.UpdateConf)
+	repo.Configure(cfg)
+	if *offlineBundlePath == "" {
+		return repo, nil
+	}
 
-	poll := time.NewTicker(interval)
-	for {
-		<-poll.C
-		err := pollCoreOSReleases(repo)
-		repo.UpdateError(err)
+	if *offlineBundleKey == "" {
+		return nil, errors.New("The --offline-bundle-key is required to verify the offline bundle")
+	}
+	key, err := parseBundlePublicKey(*offlineBundleKey)
+	if err != nil {
+		return nil, err
 	}
+	bundle, err := readOfflineBundle(*offlineBundlePath, key)
+	if err != nil {
+		return nil, err
+	}
+	log.WithField("bundle", *offlineBundlePath).WithField("created", bundle.created).Info("Serving the releases from the offline bundle.")
+	repo.UseOfflineBundle(bundle)
+	return repo, nil
 }
 
-func pollCoreOSReleases(repo *releaseRepository) error {
+func pollCoreOSReleases(ctx context.Context, repo *releaseRepository) error {
+	ctx, cancel := repo.BeginPoll(ctx)
+	defer cancel()
+
 	err := repo.GetChannel()
 	if err != nil {
 		log.WithError(err).Error("Failed to retrieve the channel from CoreOS update.conf.")
 		return err
 	}
 
-	err = repo.GetInstalledVersion()
+	err = repo.GetInstalledVersion(ctx)
 	if err != nil {
 		log.WithError(err).Error("Failed to retrieve the currently installed version.")
 		return err
 	}
 
-	err = repo.GetLatestVersion()
+	err = repo.GetLatestVersion(ctx)
 	if err != nil {
 		log.WithError(err).Error("Failed to retrieve the latest remote coreOS Release.")
 		return err
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	nodeOSStatusGroup   = "coreos-version-checker.ft.com"
+	nodeOSStatusVersion = "v1alpha1"
+	nodeOSStatusPath    = "/apis/" + nodeOSStatusGroup + "/" + nodeOSStatusVersion + "/nodeosstatuses"
+)
+
+// nodeOSStatus is the cluster scoped NodeOSStatus custom resource, named after the node it describes.
+type nodeOSStatus struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   kubeObjectMeta     `json:"metadata"`
+	Status     nodeOSStatusStatus `json:"status"`
+}
+
+type nodeOSStatusStatus struct {
+	Node               string              `json:"node"`
+	Channel            string              `json:"channel"`
+	Board              string              `json:"board"`
+	Installed          nodeOSStatusRelease `json:"installed"`
+	Latest             nodeOSStatusRelease `json:"latest"`
+	UpToDate           bool                `json:"upToDate"`
+	Severity           string              `json:"severity"`
+	PendingCVEs        []nodeOSStatusCVE   `json:"pendingCVEs"`
+	Deadline           *time.Time          `json:"deadline"`
+	Overdue            bool                `json:"overdue"`
+	LastSuccessfulPoll *time.Time          `json:"lastSuccessfulPoll"`
+	PollError          string              `json:"pollError"`
+}
+
+type nodeOSStatusRelease struct {
+	Version     string     `json:"version"`
+	ReleaseDate *time.Time `json:"releaseDate"`
+	MaxCVSS     *float64   `json:"maxCvss"`
+}
+
+type nodeOSStatusCVE struct {
+	ID   string  `json:"id"`
+	CVSS float64 `json:"cvss"`
+	Link string  `json:"link"`
+}
+
+// nodeOSStatusPublisher creates and updates the NodeOSStatus custom resource for the node after every poll.
+type nodeOSStatusPublisher struct {
+	sync.Mutex
+	client kubernetesClient
+	node   string
+	now    func() time.Time
+}
+
+func newNodeOSStatusPublisher(client kubernetesClient, node string) *nodeOSStatusPublisher {
+	return &nodeOSStatusPublisher{
+		client: client,
+		node:   node,
+		now:    time.Now,
+	}
+}
+
+// Observe is registered with the refresher to be called after every poll.
+func (p *nodeOSStatusPublisher) Observe(ctx context.Context, state releaseState) {
+	p.Lock()
+	defer p.Unlock()
+
+	status := p.status(state)
+	err := p.client.PatchNodeOSStatus(ctx, p.node, map[string]interface{}{"status": status})
+	if apiErr, ok := err.(*kubeAPIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		err = p.client.CreateNodeOSStatus(ctx, nodeOSStatus{
+			APIVersion: nodeOSStatusGroup + "/" + nodeOSStatusVersion,
+			Kind:       "NodeOSStatus",
+			Metadata:   kubeObjectMeta{Name: p.node},
+			Status:     status,
+		})
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("node", p.node).Error("Failed to publish the NodeOSStatus.")
+	}
+}
+
+func (p *nodeOSStatusPublisher) status(state releaseState) nodeOSStatusStatus {
+	installed, latest := state.InstalledVersion, state.LatestVersion
+	status := nodeOSStatusStatus{
+		Node:               p.node,
+		Channel:            state.Channel,
+		Board:              state.Board,
+		Installed:          nodeOSStatusRelease{Version: installed.Version, ReleaseDate: installed.ReleaseDate, MaxCVSS: installed.MaxCVSS},
+		Latest:             nodeOSStatusRelease{Version: latest.Version, ReleaseDate: latest.ReleaseDate, MaxCVSS: latest.MaxCVSS},
+		UpToDate:           installed.Version == latest.Version,
+		Severity:           "none",
+		PendingCVEs:        []nodeOSStatusCVE{},
+		LastSuccessfulPoll: state.LastSuccess,
+		PollError:          state.Error,
+	}
+
+	if state.LastSuccess == nil || status.UpToDate {
+		return status
+	}
+
+	status.Severity = severityBand(latest.MaxCVSS)
+	for _, fix := range latest.SecurityFixes {
+		status.PendingCVEs = append(status.PendingCVEs, nodeOSStatusCVE{ID: fix.ID, CVSS: fix.CVSS, Link: fmt.Sprintf(cveLinkURI, fix.ID)})
+	}
+	if deadline, ok := securityDeadline(latest); ok {
+		status.Deadline = &deadline
+		status.Overdue = p.now().After(deadline)
+	}
+	return status
+}
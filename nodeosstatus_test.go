@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeOSStatusPublisher(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeKubernetesClient{}
+	p := newNodeOSStatusPublisher(fake, "node-1")
+	p.now = func() time.Time { return now }
+
+	state := newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour*72))
+	p.Observe(context.Background(), state)
+
+	created, ok := fake.osStatuses["node-1"]
+	assert.True(t, ok)
+	assert.Equal(t, "coreos-version-checker.ft.com/v1alpha1", created.APIVersion)
+	assert.Equal(t, "NodeOSStatus", created.Kind)
+
+	status := created.Status
+	assert.Equal(t, "stable", status.Channel)
+	assert.Equal(t, "2135.4.0", status.Installed.Version)
+	assert.Equal(t, "2135.5.0", status.Latest.Version)
+	assert.False(t, status.UpToDate)
+	assert.Equal(t, "critical", status.Severity)
+	assert.True(t, status.Overdue)
+	assert.Equal(t, now.Add(-time.Hour*24), *status.Deadline)
+	if assert.Len(t, status.PendingCVEs, 1) {
+		assert.Equal(t, 9.8, status.PendingCVEs[0].CVSS)
+		assert.Contains(t, status.PendingCVEs[0].Link, status.PendingCVEs[0].ID)
+	}
+
+	state.InstalledVersion.Version = "2135.5.0"
+	state.Error = "failed to retrieve the latest release"
+	p.Observe(context.Background(), state)
+
+	status = fake.osStatuses["node-1"].Status
+	assert.True(t, status.UpToDate)
+	assert.Equal(t, "none", status.Severity)
+	assert.Empty(t, status.PendingCVEs)
+	assert.Nil(t, status.Deadline)
+	assert.Equal(t, "failed to retrieve the latest release", status.PollError)
+}
+
+func TestNodeOSStatusPublisherReportsPollErrorsBeforeFirstSuccess(t *testing.T) {
+	fake := &fakeKubernetesClient{}
+	p := newNodeOSStatusPublisher(fake, "node-1")
+
+	p.Observe(context.Background(), releaseState{Error: "no such host"})
+	status := fake.osStatuses["node-1"].Status
+	assert.Equal(t, "no such host", status.PollError)
+	assert.Nil(t, status.LastSuccessfulPoll)
+}
+
+func TestNodeOSStatusPublisherFailure(t *testing.T) {
+	fake := &fakeKubernetesClient{err: errors.New("nodeosstatuses is forbidden")}
+	p := newNodeOSStatusPublisher(fake, "node-1")
+
+	p.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", -1, time.Now()))
+	assert.Empty(t, fake.osStatuses)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const nodeMetadataPrefix = "coreos-version-checker/"
+
+// nodeStatusPublisher writes the findings of every poll onto the labels and annotations of the node the checker is
+// running on, so they can be used by schedulers, kubectl and other tooling.
+type nodeStatusPublisher struct {
+	sync.Mutex
+	client    kubernetesClient
+	node      string
+	published map[string]interface{}
+	now       func() time.Time
+}
+
+func newNodeStatusPublisher(client kubernetesClient, node string) *nodeStatusPublisher {
+	return &nodeStatusPublisher{
+		client: client,
+		node:   node,
+		now:    time.Now,
+	}
+}
+
+// Observe is registered with the refresher to be called after every poll.
+func (p *nodeStatusPublisher) Observe(ctx context.Context, state releaseState) {
+	if state.LastSuccess == nil {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	metadata := p.metadata(state)
+	if reflect.DeepEqual(metadata, p.published) {
+		return
+	}
+
+	patch := map[string]interface{}{"metadata": metadata}
+	if err := p.client.PatchNode(ctx, p.node, patch); err != nil {
+		log.WithError(err).WithField("node", p.node).Error("Failed to publish the CoreOS status onto the node.")
+		return
+	}
+	p.published = metadata
+}
+
+func (p *nodeStatusPublisher) metadata(state releaseState) map[string]interface{} {
+	latest := state.LatestVersion
+	upToDate := state.InstalledVersion.Version == latest.Version
+
+	overdue := false
+	var deadline interface{}
+	if d, ok := securityDeadline(latest); ok && !upToDate {
+		overdue = p.now().After(d)
+		deadline = d.UTC().Format(time.RFC3339)
+	}
+
+	var maxCVSS interface{}
+	if latest.MaxCVSS != nil && *latest.MaxCVSS >= 0 {
+		maxCVSS = strconv.FormatFloat(*latest.MaxCVSS, 'f', 1, 64)
+	}
+
+	severity := "none"
+	if !upToDate {
+		severity = severityBand(latest.MaxCVSS)
+	}
+
+	var board interface{}
+	if state.Board != "" {
+		board = state.Board
+	}
+
+	// nil values remove the annotation through the merge patch
+	return map[string]interface{}{
+		"labels": map[string]interface{}{
+			nodeMetadataPrefix + "up-to-date": strconv.FormatBool(upToDate),
+			nodeMetadataPrefix + "overdue":    strconv.FormatBool(overdue),
+			nodeMetadataPrefix + "severity":   severity,
+			nodeMetadataPrefix + "channel":    state.Channel,
+			nodeMetadataPrefix + "board":      board,
+		},
+		"annotations": map[string]interface{}{
+			nodeMetadataPrefix + "installed-version": state.InstalledVersion.Version,
+			nodeMetadataPrefix + "latest-version":    latest.Version,
+			nodeMetadataPrefix + "max-cvss":          maxCVSS,
+			nodeMetadataPrefix + "deadline":          deadline,
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeStatusPublisher(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeKubernetesClient{}
+	p := newNodeStatusPublisher(fake, "node-1")
+	p.now = func() time.Time { return now }
+
+	state := newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour*72))
+	p.Observe(context.Background(), state)
+	p.Observe(context.Background(), state)
+	assert.Len(t, fake.nodePatches, 1)
+
+	metadata := fake.nodePatches[0].(map[string]interface{})["metadata"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"coreos-version-checker/up-to-date": "false",
+		"coreos-version-checker/overdue":    "true",
+		"coreos-version-checker/severity":   "critical",
+		"coreos-version-checker/channel":    "stable",
+		"coreos-version-checker/board":      nil,
+	}, metadata["labels"])
+	assert.Equal(t, map[string]interface{}{
+		"coreos-version-checker/installed-version": "2135.4.0",
+		"coreos-version-checker/latest-version":    "2135.5.0",
+		"coreos-version-checker/max-cvss":          "9.8",
+		"coreos-version-checker/deadline":          "2019-06-30T00:00:00Z",
+	}, metadata["annotations"])
+
+	state.InstalledVersion.Version = "2135.5.0"
+	p.Observe(context.Background(), state)
+	assert.Len(t, fake.nodePatches, 2)
+
+	metadata = fake.nodePatches[1].(map[string]interface{})["metadata"].(map[string]interface{})
+	assert.Equal(t, "true", metadata["labels"].(map[string]interface{})["coreos-version-checker/up-to-date"])
+	assert.Equal(t, "none", metadata["labels"].(map[string]interface{})["coreos-version-checker/severity"])
+	assert.Nil(t, metadata["annotations"].(map[string]interface{})["coreos-version-checker/deadline"])
+}
+
+func TestNodeStatusPublisherRetriesFailedPatch(t *testing.T) {
+	fake := &fakeKubernetesClient{err: errors.New("nodes is forbidden")}
+	p := newNodeStatusPublisher(fake, "node-1")
+
+	state := newTestState("2135.4.0", "2135.5.0", -1, time.Now())
+	p.Observe(context.Background(), state)
+	assert.Empty(t, fake.nodePatches)
+
+	fake.err = nil
+	p.Observe(context.Background(), state)
+	assert.Len(t, fake.nodePatches, 1)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type eventType string
+
+const (
+	eventNewVersion          eventType = "new-version"
+	eventSecurityFix         eventType = "security-fix"
+	eventDeadlineApproaching eventType = "deadline-approaching"
+	eventDeadlinePassed      eventType = "deadline-passed"
+	eventResolved            eventType = "resolved"
+)
+
+// eventRanks orders the conditions from least to most severe. Only the most severe newly raised condition a notifier
+// is subscribed to is notified for each poll, so a release with an overdue fix produces one notification rather than
+// four.
+var eventRanks = []eventType{eventNewVersion, eventSecurityFix, eventDeadlineApproaching, eventDeadlinePassed}
+
+// event describes a change in the upgrade status of the node.
+type event struct {
+	Type          eventType  `json:"type"`
+	Time          time.Time  `json:"time"`
+	Node          string     `json:"node"`
+	Channel       string     `json:"channel"`
+	Board         string     `json:"board,omitempty"`
+	Installed     string     `json:"installedVersion"`
+	Latest        string     `json:"latestVersion"`
+	ReleaseDate   *time.Time `json:"releaseDate,omitempty"`
+	MaxCVSS       float64    `json:"maxCvss"`
+	SecurityFixes []cve      `json:"securityFixes,omitempty"`
+	Deadline      *time.Time `json:"deadline,omitempty"`
+}
+
+type notifier interface {
+	Name() string
+	Notify(ctx context.Context, e event) error
+}
+
+// subscriber is implemented by the notifiers which only want some types of event. The subscription is applied
+// before the most severe event of a poll is chosen, so a subscribed event is not dropped for a more severe one.
+type subscriber interface {
+	Subscribed(t eventType) bool
+}
+
+// eventRecorder is implemented by the notifiers which record every newly raised condition as it happened, rather
+// than the most severe of a poll.
+type eventRecorder interface {
+	recordsEveryEvent() bool
+}
+
+// Summary returns a one line, human readable description of the event.
+func (e event) Summary() string {
+	switch e.Type {
+	case eventNewVersion:
+		return fmt.Sprintf("New CoreOS version %s is available on %s (installed %s).", e.Latest, e.Node, e.Installed)
+	case eventSecurityFix:
+		return fmt.Sprintf("New CoreOS version %s available on %s contains %d security fix(es), max CVSS %.1f.", e.Latest, e.Node, len(e.SecurityFixes), e.MaxCVSS)
+	case eventDeadlineApproaching:
+		return fmt.Sprintf("%s must be upgraded to CoreOS %s by %s.", e.Node, e.Latest, e.Deadline.UTC().Format(releaseDateFormat))
+	case eventDeadlinePassed:
+		return fmt.Sprintf("%s has passed the deadline of %s to upgrade to CoreOS %s!", e.Node, e.Deadline.UTC().Format(releaseDateFormat), e.Latest)
+	case eventResolved:
+		return fmt.Sprintf("%s has been upgraded to CoreOS %s.", e.Node, e.Installed)
+	}
+	return string(e.Type)
+}
+
+// eventWatcher evaluates the repository state after every poll, and notifies when the upgrade status of the node
+// changes. Each condition is only notified once per latest version, so repeated polls do not repeat notifications.
+type eventWatcher struct {
+	sync.Mutex
+	node            string
+	deadlineWarning time.Duration
+	notifiers       []notifier
+	active          map[eventType]string
+	stateFile       string
+	now             func() time.Time
+}
+
+// notifiedState is the record of the conditions which have been notified, with the latest version each was raised
+// for.
+type notifiedState struct {
+	Active map[eventType]string `json:"active"`
+}
+
+func newEventWatcher(node string, deadlineWarning time.Duration, notifiers ...notifier) *eventWatcher {
+	return &eventWatcher{
+		node:            node,
+		deadlineWarning: deadlineWarning,
+		notifiers:       notifiers,
+		active:          make(map[eventType]string),
+		now:             time.Now,
+	}
+}
+
+// PersistTo keeps the notified conditions in the file, loading those notified before a restart. The upgrade which
+// resolves the conditions reboots the node, so without it the upgrade is never notified and the conditions which
+// are still active are notified again.
+func (w *eventWatcher) PersistTo(path string) error {
+	w.Lock()
+	defer w.Unlock()
+	w.stateFile = path
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state notifiedState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return err
+	}
+	if state.Active != nil {
+		w.active = state.Active
+	}
+	return nil
+}
+
+// save expects the caller to hold the lock.
+func (w *eventWatcher) save() {
+	if w.stateFile == "" {
+		return
+	}
+
+	content, err := json.Marshal(notifiedState{Active: w.active})
+	if err == nil {
+		// written to a temporary file first, so a crash mid-write does not lose the state
+		tmp := w.stateFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, content, 0644); err == nil {
+			err = os.Rename(tmp, w.stateFile)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", w.stateFile).Error("Failed to save the notified conditions.")
+	}
+}
+
+// Observe is registered with the refresher to be called after every poll.
+func (w *eventWatcher) Observe(ctx context.Context, state releaseState) {
+	if state.LastSuccess == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	e := w.newEvent(state)
+	conditions := w.conditions(state, e.Deadline)
+
+	if len(conditions) == 0 {
+		if _, ok := w.active[eventNewVersion]; ok {
+			e.Type = eventResolved
+			w.notify(ctx, []event{e})
+		}
+		if len(w.active) > 0 {
+			w.active = make(map[eventType]string)
+			w.save()
+		}
+		return
+	}
+
+	var raised []eventType
+	changed := false
+	for condition := range w.active {
+		if !conditions[condition] {
+			delete(w.active, condition)
+			changed = true
+		}
+	}
+	for condition := range conditions {
+		if w.active[condition] != state.LatestVersion.Version {
+			w.active[condition] = state.LatestVersion.Version
+			raised = append(raised, condition)
+		}
+	}
+	if changed || len(raised) > 0 {
+		w.save()
+	}
+
+	if len(raised) == 0 {
+		return
+	}
+
+	sort.Slice(raised, func(i, j int) bool { return eventRank(raised[i]) < eventRank(raised[j]) })
+	events := make([]event, len(raised))
+	for i, condition := range raised {
+		events[i] = e
+		events[i].Type = condition
+	}
+	w.notify(ctx, events)
+}
+
+func (w *eventWatcher) newEvent(state releaseState) event {
+	e := event{
+		Time:          w.now(),
+		Node:          w.node,
+		Channel:       state.Channel,
+		Board:         state.Board,
+		Installed:     state.InstalledVersion.Version,
+		Latest:        state.LatestVersion.Version,
+		ReleaseDate:   state.LatestVersion.ReleaseDate,
+		SecurityFixes: state.LatestVersion.SecurityFixes,
+	}
+	if state.LatestVersion.MaxCVSS != nil {
+		e.MaxCVSS = *state.LatestVersion.MaxCVSS
+	}
+	if deadline, ok := securityDeadline(state.LatestVersion); ok {
+		e.Deadline = &deadline
+	}
+	return e
+}
+
+func (w *eventWatcher) conditions(state releaseState, deadline *time.Time) map[eventType]bool {
+	conditions := make(map[eventType]bool)
+	if state.InstalledVersion.Version == state.LatestVersion.Version {
+		return conditions
+	}
+
+	conditions[eventNewVersion] = true
+	if state.LatestVersion.MaxCVSS != nil && *state.LatestVersion.MaxCVSS > 0 {
+		conditions[eventSecurityFix] = true
+	}
+
+	if deadline != nil {
+		now := w.now()
+		switch {
+		case now.After(*deadline):
+			conditions[eventDeadlinePassed] = true
+		case now.Add(w.deadlineWarning).After(*deadline):
+			conditions[eventDeadlineApproaching] = true
+		}
+	}
+	return conditions
+}
+
+// notify sends each notifier the most severe of the events, ordered from least to most severe, which it is
+// subscribed to, or all of them if it records every event.
+func (w *eventWatcher) notify(ctx context.Context, events []event) {
+	for _, n := range w.notifiers {
+		for _, e := range eventsFor(n, events) {
+			if err := n.Notify(ctx, e); err != nil {
+				log.WithError(err).WithField("notifier", n.Name()).WithField("event", e.Type).Error("Failed to send notification.")
+				continue
+			}
+			log.WithField("notifier", n.Name()).WithField("event", e.Type).Info("Sent notification.")
+		}
+	}
+}
+
+func eventsFor(n notifier, events []event) []event {
+	var subscribed []event
+	for _, e := range events {
+		if s, ok := n.(subscriber); ok && !s.Subscribed(e.Type) {
+			continue
+		}
+		subscribed = append(subscribed, e)
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+	if r, ok := n.(eventRecorder); ok && r.recordsEveryEvent() {
+		return subscribed
+	}
+	return subscribed[len(subscribed)-1:]
+}
+
+func eventRank(t eventType) int {
+	for i, ranked := range eventRanks {
+		if ranked == t {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNotifier struct {
+	events []event
+	err    error
+}
+
+func (f *fakeNotifier) Name() string {
+	return "fake"
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, e event) error {
+	f.events = append(f.events, e)
+	return f.err
+}
+
+func newTestState(installed string, latest string, maxCVSS float64, released time.Time) releaseState {
+	lastSuccess := time.Now()
+	return releaseState{
+		Channel:          "stable",
+		InstalledVersion: coreOSRelease{Version: installed},
+		LatestVersion: coreOSRelease{
+			Version:       latest,
+			MaxCVSS:       &maxCVSS,
+			ReleaseDate:   &released,
+			SecurityFixes: []cve{{ID: "CVE-2019-0001", CVSS: maxCVSS}},
+		},
+		LastSuccess: &lastSuccess,
+	}
+}
+
+func TestEventWatcherTransitions(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeNotifier{}
+	w := newEventWatcher("node-1", time.Hour*24, fake)
+	w.now = func() time.Time { return now }
+
+	released := now.Add(-time.Hour * 24 * 10)
+
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.4.0", -1, released))
+	assert.Empty(t, fake.events)
+
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", -1, released))
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", -1, released))
+	assert.Len(t, fake.events, 1)
+	assert.Equal(t, eventNewVersion, fake.events[0].Type)
+	assert.Equal(t, "node-1", fake.events[0].Node)
+
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.6.0", 7.5, released))
+	assert.Len(t, fake.events, 2)
+	assert.Equal(t, eventSecurityFix, fake.events[1].Type)
+	assert.Equal(t, released.Add(highSecurityFixDeadline), *fake.events[1].Deadline)
+
+	now = released.Add(highSecurityFixDeadline - time.Hour)
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.6.0", 7.5, released))
+	assert.Len(t, fake.events, 3)
+	assert.Equal(t, eventDeadlineApproaching, fake.events[2].Type)
+
+	now = released.Add(highSecurityFixDeadline + time.Hour)
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.6.0", 7.5, released))
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.6.0", 7.5, released))
+	assert.Len(t, fake.events, 4)
+	assert.Equal(t, eventDeadlinePassed, fake.events[3].Type)
+
+	w.Observe(context.Background(), newTestState("2135.6.0", "2135.6.0", 7.5, released))
+	w.Observe(context.Background(), newTestState("2135.6.0", "2135.6.0", 7.5, released))
+	assert.Len(t, fake.events, 5)
+	assert.Equal(t, eventResolved, fake.events[4].Type)
+	assert.Equal(t, "node-1 has been upgraded to CoreOS 2135.6.0.", fake.events[4].Summary())
+}
+
+func TestEventWatcherMostSevereEventOnly(t *testing.T) {
+	fake := &fakeNotifier{}
+	w := newEventWatcher("node-1", time.Hour*24, fake)
+
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.6.0", 9.8, time.Now().Add(-time.Hour*72)))
+	assert.Len(t, fake.events, 1)
+	assert.Equal(t, eventDeadlinePassed, fake.events[0].Type)
+}
+
+func TestEventWatcherIgnoresStateBeforeFirstSuccess(t *testing.T) {
+	fake := &fakeNotifier{err: errors.New("slack is down")}
+	w := newEventWatcher("node-1", time.Hour*24, fake)
+
+	state := newTestState("2135.4.0", "2135.6.0", 9.8, time.Now())
+	state.LastSuccess = nil
+	w.Observe(context.Background(), state)
+	assert.Empty(t, fake.events)
+}
+
+func TestEventWatcherPersistsAcrossRestart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "notified")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notified.json")
+
+	fake := &fakeNotifier{}
+	w := newEventWatcher("node-1", time.Hour*24, fake)
+	assert.NoError(t, w.PersistTo(path))
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", -1, time.Now()))
+	assert.Len(t, fake.events, 1)
+
+	// the pod restarts with the node still on the old version
+	restarted := newEventWatcher("node-1", time.Hour*24, fake)
+	assert.NoError(t, restarted.PersistTo(path))
+	restarted.Observe(context.Background(), newTestState("2135.4.0", "2135.5.0", -1, time.Now()))
+	assert.Len(t, fake.events, 1, "the active conditions are not notified again")
+
+	// and again after the reboot into the upgrade
+	upgraded := newEventWatcher("node-1", time.Hour*24, fake)
+	assert.NoError(t, upgraded.PersistTo(path))
+	upgraded.Observe(context.Background(), newTestState("2135.5.0", "2135.5.0", -1, time.Now()))
+	assert.Len(t, fake.events, 2)
+	assert.Equal(t, eventResolved, fake.events[1].Type)
+}
+
+// subscribedNotifier only wants the new version events.
+type subscribedNotifier struct {
+	fakeNotifier
+}
+
+func (s *subscribedNotifier) Subscribed(t eventType) bool {
+	return t == eventNewVersion
+}
+
+func TestEventWatcherSubscriptionBeforeRanking(t *testing.T) {
+	fake := &fakeNotifier{}
+	subscribed := &subscribedNotifier{}
+	w := newEventWatcher("node-1", time.Hour*24, fake, subscribed)
+
+	w.Observe(context.Background(), newTestState("2135.4.0", "2135.6.0", 9.8, time.Now().Add(-time.Hour*72)))
+	assert.Len(t, fake.events, 1)
+	assert.Equal(t, eventDeadlinePassed, fake.events[0].Type)
+	assert.Len(t, subscribed.events, 1, "the new version is not dropped for the more severe deadline")
+	assert.Equal(t, eventNewVersion, subscribed.events[0].Type)
+}
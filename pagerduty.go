@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+const pagerDutyEventsURI = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Component     string                 `json:"component"`
+	Class         string                 `json:"class"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDutyNotifier pages through the PagerDuty Events API v2 for every CVE in the latest release which is either
+// critical, or high and past its upgrade deadline. Each CVE is triggered with a dedup key for the node, version and
+// CVE, and is resolved once it no longer qualifies, i.e. when the installed version catches up.
+type pagerDutyNotifier struct {
+	sync.Mutex
+	client     *retryablehttp.Client
+	uri        string
+	routingKey string
+	node       string
+	triggered  map[string]bool
+	// resolvedFor is the installed version whose incidents have been resolved since the notifier started
+	resolvedFor string
+	now         func() time.Time
+}
+
+func newPagerDutyNotifier(client *retryablehttp.Client, routingKey string, node string) *pagerDutyNotifier {
+	return &pagerDutyNotifier{
+		client:     client,
+		uri:        pagerDutyEventsURI,
+		routingKey: routingKey,
+		node:       node,
+		triggered:  make(map[string]bool),
+		now:        time.Now,
+	}
+}
+
+// Observe is registered with the refresher to be called after every poll.
+func (p *pagerDutyNotifier) Observe(ctx context.Context, state releaseState) {
+	if state.LastSuccess == nil {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	pages := p.pages(state)
+	for key, payload := range pages {
+		if p.triggered[key] {
+			continue
+		}
+		if err := p.send(ctx, "trigger", key, payload); err != nil {
+			log.WithError(err).WithField("dedupKey", key).Error("Failed to trigger PagerDuty incident.")
+			continue
+		}
+		p.triggered[key] = true
+	}
+
+	resolved := make(map[string]bool)
+	for key := range p.triggered {
+		if _, ok := pages[key]; ok {
+			continue
+		}
+		if p.resolve(ctx, key) {
+			resolved[key] = true
+		}
+	}
+
+	// the triggered incidents are forgotten when the pod restarts, as it does on the reboot into the upgrade, so the
+	// incidents of the installed version are resolved once for each version; resolving is idempotent in PagerDuty
+	installed := state.InstalledVersion
+	if installed.Version == p.resolvedFor {
+		return
+	}
+	policy := currentPolicy()
+	complete := true
+	for _, fix := range installed.SecurityFixes {
+		key := p.dedupKey(installed.Version, fix.ID)
+		if fix.CVSS < policy.HighCVSS || resolved[key] {
+			continue
+		}
+		complete = p.resolve(ctx, key) && complete
+	}
+	if complete {
+		p.resolvedFor = installed.Version
+	}
+}
+
+// resolve expects the caller to hold the lock.
+func (p *pagerDutyNotifier) resolve(ctx context.Context, key string) bool {
+	if err := p.send(ctx, "resolve", key, nil); err != nil {
+		log.WithError(err).WithField("dedupKey", key).Error("Failed to resolve PagerDuty incident.")
+		return false
+	}
+	delete(p.triggered, key)
+	return true
+}
+
+func (p *pagerDutyNotifier) dedupKey(version string, id string) string {
+	return fmt.Sprintf("coreos-version-checker/%s/%s/%s", p.node, version, id)
+}
+
+func (p *pagerDutyNotifier) pages(state releaseState) map[string]*pagerDutyPayload {
+	pages := make(map[string]*pagerDutyPayload)
+
+	latest := state.LatestVersion
+	if state.InstalledVersion.Version == latest.Version {
+		return pages
+	}
+
+	deadline, hasDeadline := securityDeadline(latest)
+	overdue := hasDeadline && p.now().After(deadline)
+	policy := currentPolicy()
+
+	for _, fix := range latest.SecurityFixes {
+		var class string
+		switch {
+		case fix.CVSS >= policy.CriticalCVSS:
+			class = "critical security fix"
+		case fix.CVSS >= policy.HighCVSS && overdue:
+			class = "overdue high security fix"
+		default:
+			continue
+		}
+
+		details := map[string]interface{}{
+			"installed_version": state.InstalledVersion.Version,
+			"latest_version":    latest.Version,
+			"channel":           state.Channel,
+			"cve":               fix.ID,
+			"cvss":              fix.CVSS,
+			"cve_link":          fmt.Sprintf(cveLinkURI, fix.ID),
+		}
+		if state.Board != "" {
+			details["board"] = state.Board
+		}
+		if hasDeadline {
+			details["deadline"] = deadline.UTC().Format(time.RFC3339)
+		}
+
+		key := p.dedupKey(latest.Version, fix.ID)
+		pages[key] = &pagerDutyPayload{
+			Summary:       fmt.Sprintf("%s must be upgraded to CoreOS %s: %s %s (CVSS %.1f)", p.node, latest.Version, class, fix.ID, fix.CVSS),
+			Source:        p.node,
+			Severity:      "critical",
+			Timestamp:     p.now(),
+			Component:     "coreos",
+			Class:         class,
+			CustomDetails: details,
+		}
+	}
+	return pages
+}
+
+func (p *pagerDutyNotifier) send(ctx context.Context, action string, key string, payload *pagerDutyPayload) error {
+	return PostJSON(ctx, p.client, p.uri, pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: action,
+		DedupKey:    key,
+		Payload:     payload,
+	})
+}
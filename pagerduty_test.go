@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPagerDutyNotifier(t *testing.T) {
+	var events []pagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e pagerDutyEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events = append(events, e)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	p := newPagerDutyNotifier(newRetryableClient(&http.Client{}), "routing-key", "node-1")
+	p.uri = server.URL
+	p.now = func() time.Time { return now }
+
+	state := newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour))
+	state.LatestVersion.SecurityFixes = []cve{
+		{ID: "CVE-2019-0001", CVSS: 9.8},
+		{ID: "CVE-2019-0002", CVSS: 7.5},
+		{ID: "CVE-2019-0003", CVSS: 4},
+	}
+
+	p.Observe(context.Background(), state)
+	p.Observe(context.Background(), state)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "trigger", events[0].EventAction)
+	assert.Equal(t, "routing-key", events[0].RoutingKey)
+	assert.Equal(t, "coreos-version-checker/node-1/2135.5.0/CVE-2019-0001", events[0].DedupKey)
+	assert.Equal(t, "critical", events[0].Payload.Severity)
+	assert.Equal(t, "node-1", events[0].Payload.Source)
+
+	now = now.Add(criticalSecurityFixDeadline)
+	p.Observe(context.Background(), state)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "coreos-version-checker/node-1/2135.5.0/CVE-2019-0002", events[1].DedupKey)
+	assert.Equal(t, "overdue high security fix", events[1].Payload.Class)
+
+	state.InstalledVersion.Version = "2135.5.0"
+	p.Observe(context.Background(), state)
+	assert.Len(t, events, 4)
+	for _, e := range events[2:] {
+		assert.Equal(t, "resolve", e.EventAction)
+		assert.Nil(t, e.Payload)
+	}
+	assert.Empty(t, p.triggered)
+}
+
+func TestPagerDutyNotifierNoPagesForHighBeforeDeadline(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	p := newPagerDutyNotifier(newRetryableClient(&http.Client{}), "routing-key", "node-1")
+	p.now = func() time.Time { return now }
+
+	state := newTestState("2135.4.0", "2135.5.0", 7.5, now.Add(-time.Hour))
+	assert.Empty(t, p.pages(state))
+}
+
+func TestPagerDutyNotifierResolvesAfterRestart(t *testing.T) {
+	var events []pagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e pagerDutyEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events = append(events, e)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	// a fresh notifier, as after the reboot into the upgrade, has no record of the incidents it triggered
+	p := newPagerDutyNotifier(newRetryableClient(&http.Client{}), "routing-key", "node-1")
+	p.uri = server.URL
+
+	state := newTestState("2135.5.0", "2135.5.0", 9.8, time.Now())
+	state.InstalledVersion.SecurityFixes = []cve{{ID: "CVE-2019-0001", CVSS: 9.8}, {ID: "CVE-2019-0003", CVSS: 4}}
+
+	p.Observe(context.Background(), state)
+	assert.Len(t, events, 1, "only the fixes which could have paged are resolved")
+	assert.Equal(t, "resolve", events[0].EventAction)
+	assert.Equal(t, "coreos-version-checker/node-1/2135.5.0/CVE-2019-0001", events[0].DedupKey)
+
+	p.Observe(context.Background(), state)
+	assert.Len(t, events, 1, "the incidents of a version are resolved once")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// pollBackoffMin is the wait after the first failed poll, which doubles with every further failure up to the poll
+// interval.
+const pollBackoffMin = 10 * time.Second
+
+// pollLoop runs the polls on every interval, retrying sooner with an exponential backoff while they fail. It records
+// when the next poll is due, so the health check can tell whether the loop is still running.
+type pollLoop struct {
+	sync.Mutex
+	refresher *refresher
+	interval  func() time.Duration
+	grace     func() time.Duration
+	failures  int
+	due       time.Time
+}
+
+// newPollLoop creates the loop; the interval and grace are read again before each wait so a config reload takes
+// effect from the next poll. The loop is considered stuck once a poll is overdue by more than the grace.
+func newPollLoop(refresher *refresher, interval func() time.Duration, grace func() time.Duration) *pollLoop {
+	return &pollLoop{refresher: refresher, interval: interval, grace: grace}
+}
+
+// Run polls until the context is done.
+func (l *pollLoop) Run(ctx context.Context) {
+	for {
+		l.Lock()
+		l.due = time.Now()
+		l.Unlock()
+
+		// a forced refresh is never rate limited
+		job, _ := l.refresher.Refresh(true)
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.done:
+		}
+
+		wait := l.next(job.Error != "")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// next counts the consecutive failed polls and returns the wait until the next poll.
+func (l *pollLoop) next(failed bool) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	wait := l.interval()
+	if failed {
+		l.failures++
+		wait = pollBackoff(l.failures, wait)
+	} else {
+		l.failures = 0
+	}
+	l.due = time.Now().Add(wait)
+	return wait
+}
+
+// pollBackoff doubles the wait with every failure up to the interval, with a random jitter of up to half the wait
+// so the checkers of a fleet don't retry in lockstep after an outage of coreos.com.
+func pollBackoff(failures int, interval time.Duration) time.Duration {
+	wait := interval
+	if failures < 32 {
+		wait = pollBackoffMin << uint(failures-1)
+	}
+	if wait > interval {
+		wait = interval
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// Liveness fails once the next poll is overdue by more than the grace, as it would be if the loop had stopped.
+func (l *pollLoop) Liveness() (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.due.IsZero() {
+		return "", errors.New("The poll loop has not been started")
+	}
+	if overdue := time.Since(l.due); overdue > l.grace() {
+		return "", fmt.Errorf("The poll loop has stopped, the last poll was due %v ago", overdue.Round(time.Second))
+	}
+	return fmt.Sprintf("The poll loop is running after %d consecutive failed poll(s), the next poll is due at %s.", l.failures, l.due.Format(time.RFC3339)), nil
+}
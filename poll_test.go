@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollLoopRecoversAndBacksOff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	polls := make(chan struct{}, 10)
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	r := newRefresher(ctx, repo, func(context.Context) error {
+		polls <- struct{}{}
+		var releases map[string]interface{}
+		_ = releases["2135.4.0"].(string)
+		return nil
+	}, 0)
+
+	loop := newPollLoop(r, func() time.Duration { return time.Hour }, func() time.Duration { return time.Minute })
+	go loop.Run(ctx)
+
+	<-polls
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		loop.Lock()
+		failures := loop.failures
+		loop.Unlock()
+		if failures == 1 || time.Now().After(deadline) {
+			assert.Equal(t, 1, failures)
+			break
+		}
+	}
+
+	assert.Contains(t, repo.State().Error, "The poll failed unexpectedly: interface conversion")
+
+	output, err := loop.Liveness()
+	assert.NoError(t, err)
+	assert.Contains(t, output, "after 1 consecutive failed poll(s)")
+
+	loop.Lock()
+	wait := time.Until(loop.due)
+	loop.Unlock()
+	assert.True(t, wait > 0 && wait <= pollBackoffMin, "the first retry is due within %v, not after the interval", pollBackoffMin)
+}
+
+func TestPollLoopLiveness(t *testing.T) {
+	loop := newPollLoop(nil, func() time.Duration { return time.Hour }, func() time.Duration { return time.Minute })
+	_, err := loop.Liveness()
+	assert.EqualError(t, err, "The poll loop has not been started")
+
+	loop.due = time.Now().Add(-2 * time.Minute)
+	_, err = loop.Liveness()
+	assert.EqualError(t, err, "The poll loop has stopped, the last poll was due 2m0s ago")
+
+	loop.next(false)
+	_, err = loop.Liveness()
+	assert.NoError(t, err)
+}
+
+func TestPollBackoff(t *testing.T) {
+	for failures, max := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 4: 80 * time.Second, 10: time.Hour, 100: time.Hour} {
+		wait := pollBackoff(failures, time.Hour)
+		assert.True(t, wait >= max/2 && wait <= max, "%d failures wait %v", failures, wait)
+	}
+
+	assert.Equal(t, time.Duration(0), pollBackoff(1, 0))
+}
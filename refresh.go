@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+const maxRefreshJobs = 20
+
+var errRefreshRateLimited = errors.New("A refresh was run too recently, please try again later")
+
+type refreshJob struct {
+	ID       string     `json:"id"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	done     chan struct{}
+}
+
+type refreshResponse struct {
+	Job   refreshJob    `json:"job"`
+	State *releaseState `json:"state,omitempty"`
+}
+
+// refresher runs polls of the release repository, making sure only one poll is in flight at any time.
+// Scheduled polls and on-demand refreshes both go through the refresher, and concurrent refresh requests are
+// coalesced into the poll which is already running.
+type refresher struct {
+	sync.Mutex
+	ctx         context.Context
+	repo        *releaseRepository
+	poll        func(context.Context) error
+	minInterval time.Duration
+	lastStarted time.Time
+	inFlight    *refreshJob
+	jobs        map[string]*refreshJob
+	jobIDs      []string
+	nextID      int
+	observers   []func(context.Context, releaseState)
+}
+
+// newRefresher runs the polls with the context, which cancels any poll in flight when it is done.
+func newRefresher(ctx context.Context, repo *releaseRepository, poll func(context.Context) error, minInterval time.Duration) *refresher {
+	return &refresher{
+		ctx:         ctx,
+		repo:        repo,
+		poll:        poll,
+		minInterval: minInterval,
+		jobs:        make(map[string]*refreshJob),
+	}
+}
+
+// Refresh starts a new poll, or returns the poll which is currently in flight. Unless force is set, a new poll will
+// not be started if the previous one started less than minInterval ago.
+func (r *refresher) Refresh(force bool) (*refreshJob, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.inFlight != nil {
+		return r.inFlight, nil
+	}
+
+	if !force && time.Since(r.lastStarted) < r.minInterval {
+		return nil, errRefreshRateLimited
+	}
+
+	r.nextID++
+	job := &refreshJob{
+		ID:      strconv.Itoa(r.nextID),
+		Started: time.Now(),
+		done:    make(chan struct{}),
+	}
+
+	r.lastStarted = job.Started
+	r.inFlight = job
+	r.jobs[job.ID] = job
+	r.jobIDs = append(r.jobIDs, job.ID)
+	if len(r.jobIDs) > maxRefreshJobs {
+		delete(r.jobs, r.jobIDs[0])
+		r.jobIDs = r.jobIDs[1:]
+	}
+
+	go r.run(job)
+	return job, nil
+}
+
+// OnPoll registers a function to be called with the repository state after every poll. It must be called before
+// the first poll is started. The context of the observer is cancelled on shutdown.
+func (r *refresher) OnPoll(observer func(context.Context, releaseState)) {
+	r.Lock()
+	defer r.Unlock()
+	r.observers = append(r.observers, observer)
+}
+
+// SetMinInterval changes the rate limit of the /refresh endpoint, on a config reload.
+func (r *refresher) SetMinInterval(minInterval time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.minInterval = minInterval
+}
+
+// RetryAfter returns how long until the rate limit allows a new refresh.
+func (r *refresher) RetryAfter() time.Duration {
+	r.Lock()
+	defer r.Unlock()
+	return r.minInterval - time.Since(r.lastStarted)
+}
+
+// Job returns a copy of the job with the given ID, and whether it is still known to the refresher.
+func (r *refresher) Job(id string) (refreshJob, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return refreshJob{}, false
+	}
+	return *job, true
+}
+
+func (r *refresher) run(job *refreshJob) {
+	err := r.recoveredPoll()
+
+	// a poll cancelled by the shutdown leaves the state of the last poll in place
+	cancelled := r.ctx.Err() != nil
+	if !cancelled {
+		r.repo.UpdateError(err)
+	}
+
+	r.Lock()
+	finished := time.Now()
+	job.Finished = &finished
+	if err != nil {
+		job.Error = err.Error()
+	}
+	r.inFlight = nil
+	observers := r.observers
+	close(job.done)
+	r.Unlock()
+
+	if cancelled {
+		return
+	}
+
+	state := r.repo.State()
+	for _, observer := range observers {
+		recoveredObserve(r.ctx, observer, state)
+	}
+}
+
+// recoveredObserve calls the observer, logging a panic rather than taking down the process or skipping the other
+// observers.
+func recoveredObserve(ctx context.Context, observer func(context.Context, releaseState), state releaseState) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.WithField("stack", string(debug.Stack())).Errorf("An observer of the poll panicked: %v", p)
+		}
+	}()
+	observer(ctx, state)
+}
+
+// recoveredPoll runs the poll, turning a panic into the error of the poll rather than taking down the process.
+func (r *refresher) recoveredPoll() (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.WithField("stack", string(debug.Stack())).Errorf("The poll panicked: %v", p)
+			err = fmt.Errorf("The poll failed unexpectedly: %v", p)
+		}
+	}()
+	return r.poll(r.ctx)
+}
+
+// RefreshHandler triggers a poll. By default the handler waits for the poll to complete and responds with the
+// resulting state; with ?async=true it responds immediately with the job, which can be followed up via JobHandler.
+func (r *refresher) RefreshHandler() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, err := r.Refresh(false)
+		if err == errRefreshRateLimited {
+			retryAfter := math.Ceil(r.RetryAfter().Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(retryAfter, 1))))
+			writeJSONError(w, http.StatusTooManyRequests, err)
+			return
+		}
+
+		if async, _ := strconv.ParseBool(req.URL.Query().Get("async")); async {
+			current, _ := r.Job(job.ID)
+			w.Header().Set("Location", "/refresh/"+job.ID)
+			writeJSON(w, http.StatusAccepted, refreshResponse{Job: current})
+			return
+		}
+
+		select {
+		case <-job.done:
+		case <-req.Context().Done():
+			return
+		}
+
+		r.writeJob(w, job.ID)
+	}
+}
+
+// JobHandler responds with the status of a refresh job, and the repository state once it has finished.
+func (r *refresher) JobHandler() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r.writeJob(w, mux.Vars(req)["id"])
+	}
+}
+
+func (r *refresher) writeJob(w http.ResponseWriter, id string) {
+	job, ok := r.Job(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("Refresh job not found"))
+		return
+	}
+
+	resp := refreshResponse{Job: job}
+	if job.Finished == nil {
+		writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	state := r.repo.State()
+	resp.State = &state
+	if job.Error != "" {
+		writeJSON(w, http.StatusBadGateway, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"message": err.Error()})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRefresher(poll func() error, minInterval time.Duration) (*refresher, *mux.Router) {
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	r := newRefresher(context.Background(), repo, func(context.Context) error { return poll() }, minInterval)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/refresh", r.RefreshHandler()).Methods("POST")
+	router.HandleFunc("/refresh/{id}", r.JobHandler()).Methods("GET")
+	return r, router
+}
+
+func TestRefreshCoalescesConcurrentRequests(t *testing.T) {
+	release := make(chan struct{})
+	polls := 0
+	r, _ := newTestRefresher(func() error {
+		polls++
+		<-release
+		return nil
+	}, 0)
+
+	first, err := r.Refresh(false)
+	assert.NoError(t, err)
+	second, err := r.Refresh(false)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	close(release)
+	<-first.done
+	assert.Equal(t, 1, polls)
+}
+
+func TestRefreshRecoversObserverPanic(t *testing.T) {
+	r, _ := newTestRefresher(func() error { return nil }, 0)
+	observed := make(chan struct{})
+	r.OnPoll(func(context.Context, releaseState) { panic("nil map") })
+	r.OnPoll(func(context.Context, releaseState) { close(observed) })
+
+	_, err := r.Refresh(false)
+	assert.NoError(t, err)
+	select {
+	case <-observed:
+	case <-time.After(time.Second):
+		t.Fatal("the other observers are still called")
+	}
+}
+
+func TestRefreshRateLimited(t *testing.T) {
+	r, router := newTestRefresher(func() error { return nil }, time.Hour)
+
+	job, err := r.Refresh(false)
+	assert.NoError(t, err)
+	<-job.done
+
+	_, err = r.Refresh(false)
+	assert.Equal(t, errRefreshRateLimited, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/refresh", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	forced, err := r.Refresh(true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, job.ID, forced.ID)
+}
+
+func TestRefreshHandlerWaitsForPoll(t *testing.T) {
+	r, router := newTestRefresher(func() error { return nil }, 0)
+	r.repo.channel = "stable"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/refresh", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp refreshResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.NotNil(t, resp.Job.Finished)
+	assert.Equal(t, "stable", resp.State.Channel)
+}
+
+func TestRefreshHandlerAsync(t *testing.T) {
+	release := make(chan struct{})
+	r, router := newTestRefresher(func() error {
+		<-release
+		return errors.New("coreos.com is down")
+	}, 0)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/refresh?async=true", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/refresh/1", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/refresh/1", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	close(release)
+	<-r.jobs["1"].done
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/refresh/1", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	var resp refreshResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "coreos.com is down", resp.Job.Error)
+	assert.Equal(t, "coreos.com is down", resp.State.Error)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/refresh/42", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRefreshCancelledByShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	r := newRefresher(ctx, repo, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, 0)
+
+	observed := false
+	r.OnPoll(func(context.Context, releaseState) { observed = true })
+	job, err := r.Refresh(true)
+	assert.NoError(t, err)
+
+	stopped := make(chan struct{})
+	go func() {
+		newPollLoop(r, func() time.Duration { return time.Hour }, func() time.Duration { return time.Minute }).Run(ctx)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the polls did not stop on shutdown")
+	}
+
+	<-job.done
+	assert.Equal(t, context.Canceled.Error(), job.Error)
+	assert.Empty(t, repo.State().Error, "the cancelled poll leaves the last state in place")
+	assert.False(t, observed)
+}
+
+func TestCheckRetryStopsOnCancel(t *testing.T) {
+	retry, err := checkRetry(nil, context.Canceled)
+	assert.False(t, retry)
+	assert.Equal(t, context.Canceled, err)
+
+	retry, _ = checkRetry(nil, errors.New("connection reset"))
+	assert.True(t, retry)
+}
+
+func TestDrainFinishesRefreshBeforeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	r := newRefresher(ctx, repo, func(ctx context.Context) error {
+		close(started)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}, 0)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/refresh", r.RefreshHandler()).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(server.URL+"/refresh", "application/json", nil)
+		if err != nil {
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+
+	<-started
+	drain(server.Config, cancel, time.Second)
+	assert.Equal(t, http.StatusOK, <-responses, "the synchronous refresh completes before the poll is cancelled")
+	assert.Error(t, ctx.Err())
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
@@ -21,10 +21,15 @@ var cveRegex = regexp.MustCompile(`CVE\-[0-9]{4}\-[0-9]{4,}`)
 
 const (
 	cveURI            string = "http://cve.circl.lu/api/cve/%s"
+	cveLinkURI        string = "https://cve.circl.lu/cve/%s"
 	betaReleasesURI   string = "https://coreos.com/releases/releases-beta.json"
 	alphaReleasesURI  string = "https://coreos.com/releases/releases-alpha.json"
 	stableReleasesURI string = "https://coreos.com/releases/releases-stable.json"
 	allReleasesURI    string = "https://coreos.com/releases/releases.json"
+
+	// defaultBoard is assumed when the release file has no COREOS_RELEASE_BOARD, as on images which only ship
+	// os-release.
+	defaultBoard string = "amd64-usr"
 )
 
 type cve struct {
@@ -41,71 +46,277 @@ type coreOSRelease struct {
 	ReleaseDate   *time.Time `json:"releaseDate,omitempty"`
 }
 
+// cvssError reports a CVE whose document has no usable CVSS, which retrieving it again won't change.
+type cvssError string
+
+func (e cvssError) Error() string {
+	return string(e)
+}
+
+// retryable is true when the fix could not be retrieved because of a transient failure, such as the poll deadline or
+// a transport error, rather than because of the document itself.
+func (c cve) retryable() bool {
+	switch c.err.(type) {
+	case nil, schemaError, cvssError:
+		return false
+	}
+	return true
+}
+
+// enriched is true unless a security fix of the release could not be retrieved because of a transient failure. Such
+// a release is enriched again on the next poll, even if its feed has not changed.
+func (c coreOSRelease) enriched() bool {
+	for _, fix := range c.SecurityFixes {
+		if fix.retryable() {
+			return false
+		}
+	}
+	return true
+}
+
+type releaseState struct {
+	Group            string        `json:"group,omitempty"`
+	Channel          string        `json:"channel"`
+	Board            string        `json:"board,omitempty"`
+	InstalledVersion coreOSRelease `json:"installedVersion"`
+	LatestVersion    coreOSRelease `json:"latestVersion"`
+	LastSuccess      *time.Time    `json:"lastSuccessfulPoll,omitempty"`
+	Error            string        `json:"error,omitempty"`
+}
+
 type releaseRepository struct {
 	sync.RWMutex
-	client           *retryablehttp.Client
-	channel          string
-	installedVersion coreOSRelease
-	latestVersion    coreOSRelease
-	err              error
-	releaseConfPath  string
-	updateConfPath   string
+	client                 *retryablehttp.Client
+	group                  string
+	groupMapped            bool
+	channel                string
+	channelSource          string
+	board                  string
+	installedVersion       coreOSRelease
+	latestVersion          coreOSRelease
+	err                    error
+	lastSuccess            time.Time
+	releaseConfPath        string
+	updateConfPath         string
+	updateConfDefaultsPath string
+	osReleasePath          string
+	sources                releaseSources
+	groups                 groupMappings
+	bundle                 *offlineBundle
+	bundleMaxAge           time.Duration
+	installedFeed          feedState
+	latestFeed             feedState
+	pollTimeout            time.Duration
+	cveWorkers             int
 }
 
-func newReleaseRepository(client *http.Client, releaseConfPath string, updateConfPath string) *releaseRepository {
-	retryableClient := &retryablehttp.Client{
-		HTTPClient:   client,
-		Logger:       log.New(ioutil.Discard, "", log.LstdFlags),
-		RetryWaitMin: 100 * time.Millisecond,
-		RetryWaitMax: 2 * time.Second,
-		RetryMax:     5,
-		CheckRetry:   retryablehttp.DefaultRetryPolicy,
-		Backoff:      retryablehttp.DefaultBackoff,
+// feedState records the feed the installed or latest release was last enriched from, so the enrichment is skipped
+// while the feed is unchanged.
+type feedState struct {
+	uri        string
+	version    string
+	validators feedValidators
+}
+
+// validatorsFor returns the validators to retrieve the feed with, which are only sent if the release was
+// successfully enriched from the same feed.
+func (f feedState) validatorsFor(uri string, version string) feedValidators {
+	if f.uri != uri || (version != "" && f.version != version) {
+		return feedValidators{}
 	}
+	return f.validators
+}
+
+func newReleaseRepository(client *http.Client, releaseConfPath string, updateConfPath string) *releaseRepository {
 	return &releaseRepository{
-		client:          retryableClient,
-		releaseConfPath: releaseConfPath,
-		updateConfPath:  updateConfPath,
+		client:                 newRetryableClient(client),
+		releaseConfPath:        releaseConfPath,
+		updateConfPath:         updateConfPath,
+		updateConfDefaultsPath: defaultUpdateConfDefaultsPath,
+		osReleasePath:          defaultOSReleasePath,
+		sources:                defaultReleaseSources(),
+		pollTimeout:            time.Duration(defaultConfig().PollTimeout),
+		cveWorkers:             defaultConfig().CVEWorkers,
+		bundleMaxAge:           time.Duration(defaultConfig().BundleMaxAge),
 	}
 }
 
+// Configure swaps the client, file locations and release sources on a config reload. The polls read them under the
+// lock, so it is safe to call while a poll is in flight.
+func (r *releaseRepository) Configure(cfg config) {
+	r.Lock()
+	defer r.Unlock()
+	r.client = cfg.HTTP.retryableClient()
+	r.releaseConfPath = cfg.ReleaseConf
+	r.updateConfPath = cfg.UpdateConf
+	r.updateConfDefaultsPath = cfg.UpdateConfDefaults
+	r.osReleasePath = cfg.OSRelease
+	r.sources = cfg.Sources
+	r.groups = cfg.Groups
+	r.pollTimeout = time.Duration(cfg.PollTimeout)
+	r.cveWorkers = cfg.CVEWorkers
+	r.bundleMaxAge = time.Duration(cfg.BundleMaxAge)
+}
+
+// UseOfflineBundle serves the release feeds and CVEs from the bundle rather than over HTTP.
+func (r *releaseRepository) UseOfflineBundle(bundle *offlineBundle) {
+	r.Lock()
+	defer r.Unlock()
+	r.bundle = bundle
+}
+
+// repositorySettings is a snapshot of the settings a poll runs with.
+type repositorySettings struct {
+	client                 *retryablehttp.Client
+	source                 releaseSource
+	releaseConfPath        string
+	updateConfPath         string
+	updateConfDefaultsPath string
+	osReleasePath          string
+	sources                releaseSources
+	groups                 groupMappings
+	pollTimeout            time.Duration
+	cveWorkers             int
+}
+
+func (r *releaseRepository) settings() repositorySettings {
+	r.RLock()
+	defer r.RUnlock()
+	var source releaseSource = httpReleaseSource{client: r.client}
+	if r.bundle != nil {
+		source = r.bundle
+	}
+	return repositorySettings{
+		client:                 r.client,
+		source:                 source,
+		releaseConfPath:        r.releaseConfPath,
+		updateConfPath:         r.updateConfPath,
+		updateConfDefaultsPath: r.updateConfDefaultsPath,
+		osReleasePath:          r.osReleasePath,
+		sources:                r.sources,
+		groups:                 r.groups,
+		pollTimeout:            r.pollTimeout,
+		cveWorkers:             r.cveWorkers,
+	}
+}
+
+// cveLookupKey holds the CVE lookup of a poll in its context.
+type cveLookupKey struct{}
+
+// BeginPoll shares the CVE lookups between the releases of the poll, and bounds them by the poll timeout. Only the
+// CVE enrichment is bounded, the feeds are bounded by the HTTP timeout, so a slow CVE API never fails the poll. The
+// returned cancel function ends the poll.
+func (r *releaseRepository) BeginPoll(ctx context.Context) (context.Context, context.CancelFunc) {
+	settings := r.settings()
+	lookupCtx, cancel := context.WithTimeout(ctx, settings.pollTimeout)
+	lookup := newCVELookup(lookupCtx, settings.source, settings.sources.CVE, settings.cveWorkers)
+	return context.WithValue(ctx, cveLookupKey{}, lookup), cancel
+}
+
+// cveLookup returns the lookup of the poll, or a new one outside a poll.
+func (r *releaseRepository) cveLookup(ctx context.Context) *cveLookup {
+	if lookup, ok := ctx.Value(cveLookupKey{}).(*cveLookup); ok {
+		return lookup
+	}
+	settings := r.settings()
+	return newCVELookup(ctx, settings.source, settings.sources.CVE, settings.cveWorkers)
+}
+
+// UpdateError records the outcome of a poll; a nil error marks the poll as successful.
 func (r *releaseRepository) UpdateError(err error) {
 	r.Lock()
 	defer r.Unlock()
 	r.err = err
+	if err == nil {
+		r.lastSuccess = time.Now()
+	}
+}
+
+// State returns a snapshot of the channel, releases and last poll error held by the repository.
+func (r *releaseRepository) State() releaseState {
+	r.RLock()
+	defer r.RUnlock()
+	return r.state()
+}
+
+// state expects the caller to hold the repository lock.
+func (r *releaseRepository) state() releaseState {
+	state := releaseState{
+		Group:            r.group,
+		Channel:          r.channel,
+		Board:            r.board,
+		InstalledVersion: r.installedVersion,
+		LatestVersion:    r.latestVersion,
+	}
+	if !r.lastSuccess.IsZero() {
+		lastSuccess := r.lastSuccess
+		state.LastSuccess = &lastSuccess
+	}
+	if r.err != nil {
+		state.Error = r.err.Error()
+	}
+	return state
 }
 
+// GetChannel reads the GROUP from update.conf, with /etc/coreos/update.conf overriding the defaults in
+// /usr/share/coreos/update.conf as update_engine does.
 func (r *releaseRepository) GetChannel() error {
-	channel, err := getValueFromFile("GROUP=", r.updateConfPath)
+	settings := r.settings()
+	group, err := getValueFromFile("GROUP", settings.updateConfDefaultsPath, settings.updateConfPath)
 	if err != nil {
 		return err
 	}
 
+	// in K8S we use CoreUpdate, which uses a non-standard group, like "coreUpdateChan1", which is resolved through
+	// the group mapping. If the group is not mapped, we default the channel to "stable" and report it in the health
+	channel, source, mapped := settings.groups.resolve(group)
+	if !mapped {
+		channel = "stable"
+	}
+
 	r.Lock()
 	defer r.Unlock()
-	// in K8S we use CoreUpdate, which uses a non-standard channel, like "coreUpdateChan1"
-	// if we encounter a non-standard channel, we default the channel to "stable"
-	if channel == "beta" || channel == "alpha" {
-		r.channel = channel
-	} else {
-		r.channel = "stable"
-	}
+	r.group = group
+	r.groupMapped = mapped
+	r.channel = channel
+	r.channelSource = source
 	return nil
 }
 
-func (r *releaseRepository) GetInstalledVersion() error {
-	release, err := getValueFromFile("COREOS_RELEASE_VERSION=", r.releaseConfPath)
+func (r *releaseRepository) GetInstalledVersion(ctx context.Context) error {
+	settings := r.settings()
+	release, err := getValueFromFile("COREOS_RELEASE_VERSION", settings.releaseConfPath)
 	if err != nil {
-		return err
+		// fall back to os-release, which every CoreOS and Container Linux image ships
+		var osErr error
+		release, osErr = getValueFromFile("VERSION_ID", settings.osReleasePath)
+		if osErr != nil {
+			return err
+		}
+	}
+	board, err := getValueFromFile("COREOS_RELEASE_BOARD", settings.releaseConfPath)
+	if err != nil {
+		board = defaultBoard
+	}
+	log.Printf("Currently installed version is %v on board %v", release, board)
+
+	r.RLock()
+	validators := r.installedFeed.validatorsFor(settings.sources.All, release)
+	r.RUnlock()
+
+	// only the installed release is decoded from the feed of every release
+	releases, validators, err := getReleaseFeed(ctx, settings.source, settings.sources.All, validators, func(version string) bool {
+		return version == release
+	})
+	if err == errNotModified {
+		log.Printf("%v has not changed, keeping the installed release", settings.sources.All)
+		return nil
 	}
-	log.Printf("Currently installed version is %v", release)
-
-	releases, err := GetJSON(r.client, allReleasesURI)
 	if err != nil {
 		return err
 	}
 
-	enrichedRelease, err := r.GetReleaseData(release, releases)
+	enrichedRelease, err := r.GetReleaseData(ctx, release, releases)
 	if err != nil {
 		return err
 	}
@@ -113,34 +324,53 @@ func (r *releaseRepository) GetInstalledVersion() error {
 	r.Lock()
 	defer r.Unlock()
 
+	r.board = board
 	r.installedVersion = *enrichedRelease
+	if enrichedRelease.enriched() {
+		r.installedFeed = feedState{uri: settings.sources.All, version: release, validators: validators}
+	} else {
+		r.installedFeed = feedState{}
+	}
 	return nil
 }
 
-func (r *releaseRepository) GetLatestVersion() error {
-	var uri string
-	switch r.channel {
-	case "alpha":
-		uri = alphaReleasesURI
-	case "beta":
-		uri = betaReleasesURI
-	case "stable":
-		uri = stableReleasesURI
-	default:
-		return errors.New("Unknown channel")
+func (r *releaseRepository) GetLatestVersion(ctx context.Context) error {
+	settings := r.settings()
+	r.RLock()
+	channel, uri, board := r.channel, r.channelSource, r.board
+	r.RUnlock()
+
+	if uri == "" {
+		var err error
+		if uri, err = settings.sources.channel(channel); err != nil {
+			return err
+		}
 	}
 
-	releases, err := GetJSON(r.client, uri)
+	r.RLock()
+	validators := r.latestFeed.validatorsFor(uri, "")
+	r.RUnlock()
+
+	releases, validators, err := getReleaseFeed(ctx, settings.source, uri, validators, nil)
+	if err == errNotModified {
+		log.Printf("%v has not changed, keeping the latest release", uri)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
+	releases = releasesForBoard(releases, board)
+	if len(releases) == 0 {
+		return fmt.Errorf("No %s release has been published for the %s board", channel, board)
+	}
+
 	latestRelease, err := getLatestReleaseFromJSON(releases)
 	if err != nil {
 		return err
 	}
 
-	coreOS, err := r.GetReleaseData(latestRelease, releases)
+	coreOS, err := r.GetReleaseData(ctx, latestRelease, releases)
 	if err != nil {
 		return err
 	}
@@ -149,40 +379,85 @@ func (r *releaseRepository) GetLatestVersion() error {
 	defer r.Unlock()
 
 	r.latestVersion = *coreOS
+	if coreOS.enriched() {
+		r.latestFeed = feedState{uri: uri, validators: validators}
+	} else {
+		r.latestFeed = feedState{}
+	}
 	return nil
 }
 
-func (r *releaseRepository) GetReleaseData(release string, releases map[string]interface{}) (*coreOSRelease, error) {
+func (r *releaseRepository) GetReleaseData(ctx context.Context, release string, releases releaseFeed) (*coreOSRelease, error) {
 
-	releaseData, ok := releases[release].(map[string]interface{})
+	releaseData, ok := releases[release]
 	if !ok {
 		return nil, errors.New("Release not found")
 	}
 
-	releaseNotes := releaseData["release_notes"].(string)
-	releasedOnText, ok := releaseData["release_date"]
+	releaseNotes := releaseData.ReleaseNotes
 
 	var releaseDate *time.Time
-	if ok {
-		parsed, err := time.Parse("2006-01-02 15:04:05 -0700", releasedOnText.(string))
+	if releaseData.ReleaseDate != "" {
+		parsed, err := time.Parse("2006-01-02 15:04:05 -0700", releaseData.ReleaseDate)
 		if err == nil {
 			releaseDate = &parsed
 		}
 	}
 
 	cveIDs := parseReleaseNotes(releaseNotes)
-	var securityFixes []cve
+	securityFixes := r.cveLookup(ctx).Lookup(cveIDs)
 	var maxCVSS float64 = -1
 
-	for _, cveID := range cveIDs {
-		fix := r.retrieveCVE(cveID)
-		securityFixes = append(securityFixes, fix)
+	missed := 0
+	for _, fix := range securityFixes {
 		maxCVSS = math.Max(maxCVSS, fix.CVSS)
+		if fix.err == errCVEDeadline {
+			missed++
+		}
+	}
+	if missed > 0 {
+		log.Printf("Reporting %d of the %d CVEs of %v, the others could not be retrieved before the poll deadline", len(cveIDs)-missed, len(cveIDs), release)
 	}
 	return &coreOSRelease{ReleaseDate: releaseDate, ReleaseNotes: releaseNotes, SecurityFixes: securityFixes, MaxCVSS: &maxCVSS, Version: release}, nil
 }
 
-func getLatestReleaseFromJSON(m map[string]interface{}) (string, error) {
+// releasesForBoard keeps the releases published for the board, using the architectures listed for each release in the
+// feed. A board such as arm64-usr is published as the arm64 architecture. Releases without architectures predate
+// the per-board metadata, and were only published for amd64.
+func releasesForBoard(releases releaseFeed, board string) releaseFeed {
+	if board == "" {
+		return releases
+	}
+	arch := strings.TrimSuffix(board, "-usr")
+
+	result := make(releaseFeed)
+	for version, release := range releases {
+		if release.Architectures == nil {
+			if arch == "amd64" {
+				result[version] = release
+			}
+			continue
+		}
+
+		for _, a := range release.Architectures {
+			if a == arch || a == board {
+				result[version] = release
+				break
+			}
+		}
+	}
+	return result
+}
+
+// channelDescription names the channel followed, with the board once it is known, e.g. "stable amd64-usr".
+func (s releaseState) channelDescription() string {
+	if s.Board == "" {
+		return s.Channel
+	}
+	return s.Channel + " " + s.Board
+}
+
+func getLatestReleaseFromJSON(m releaseFeed) (string, error) {
 	versions := make([]string, 0, len(m))
 	for key := range m {
 		versions = append(versions, key)
@@ -198,6 +473,12 @@ func getLatestReleaseFromJSON(m map[string]interface{}) (string, error) {
 	return "", errors.New("Version is empty")
 }
 
+// compareVersions returns -1, 0 or 1 if release a is older than, the same as or newer than release b.
+func compareVersions(a string, b string) int {
+	padded := padReleases([]string{a, b})
+	return strings.Compare(padded[0], padded[1])
+}
+
 func padReleases(releases []string) []string {
 	paddedStrings := make([]string, 0, len(releases))
 	for k := range releases {
@@ -254,20 +535,20 @@ func parseReleaseNotes(notes string) []string {
 	return result
 }
 
-func (r *releaseRepository) retrieveCVE(id string) cve {
-	cveResult, err := GetJSON(r.client, fmt.Sprintf(cveURI, id))
+func retrieveCVE(ctx context.Context, source releaseSource, uri string, id string) cve {
+	cveResult, err := getCVE(ctx, source, fmt.Sprintf(uri, id))
 	if err != nil {
 		return cve{err: err, ID: id}
 	}
 
-	cvssString, ok := cveResult["cvss"].(string)
-	if !ok {
-		return cve{err: errors.New("No CVSS found!"), ID: id}
+	if cveResult.CVSS == nil {
+		return cve{err: cvssError("No CVSS found!"), ID: id}
 	}
+	cvssString := *cveResult.CVSS
 	cvss, err := strconv.ParseFloat(cvssString, 64)
 	if err != nil {
 		return cve{
-			err: errors.New(fmt.Sprintf("Cannot parse CVSS %s because %v", cvssString, err.Error())),
+			err: cvssError(fmt.Sprintf("Cannot parse CVSS %s because %v", cvssString, err.Error())),
 			ID:  id,
 		}
 	}
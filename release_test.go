@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/jarcoal/httpmock.v1"
@@ -29,10 +32,10 @@ COREOS_RELEASE_APPID={e96281a6-d1af-4bde-9a0a-97b76e56dc57}`
 
 func TestCoreOS(t *testing.T) {
 	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
-	releases, err := GetJSON(repo.client, stableReleasesURI)
+	releases, _, err := getReleaseFeed(context.Background(), httpReleaseSource{client: repo.client}, stableReleasesURI, feedValidators{}, nil)
 	assert.NoError(t, err)
 
-	coreOS, err := repo.GetReleaseData("2135.4.0", releases)
+	coreOS, err := repo.GetReleaseData(context.Background(), "2135.4.0", releases)
 	assert.NoError(t, err)
 
 	d, _ := json.Marshal(coreOS)
@@ -77,12 +80,12 @@ func TestReleaseRepository(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, tc.expectedChannel, repo.channel)
 
-		err = repo.GetInstalledVersion()
+		err = repo.GetInstalledVersion(context.Background())
 		d, _ := json.Marshal(repo.installedVersion)
 		assert.NoError(t, err)
 		assert.Equal(t, coreosReleaseResponse, string(d))
 
-		err = repo.GetLatestVersion()
+		err = repo.GetLatestVersion(context.Background())
 		assert.NoError(t, err)
 
 		assert.NotNil(t, repo.latestVersion)
@@ -138,25 +141,212 @@ func TestReleaseRepositoryRetries(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedChannel, repo.channel)
 
-	err = repo.GetLatestVersion()
+	err = repo.GetLatestVersion(context.Background())
 	assert.Contains(t, err.Error(), "giving up")
 
 	failForAttempts = 3
 	attempt = 0
 
-	err = repo.GetLatestVersion()
+	err = repo.GetLatestVersion(context.Background())
 	assert.NoError(t, err)
 
 	assert.NotNil(t, repo.latestVersion)
 }
 
+func TestInstalledVersionFromOSRelease(t *testing.T) {
+	releaseFile, _ := ioutil.TempFile("", "release")
+	releaseFile.Write([]byte("COREOS_RELEASE_BOARD=amd64-usr\n"))
+	releaseFile.Close()
+	defer os.Remove(releaseFile.Name())
+
+	osReleaseFile, _ := ioutil.TempFile("", "os-release")
+	osReleaseFile.Write([]byte("NAME=\"Container Linux by CoreOS\"\nID=coreos\nVERSION_ID=2135.4.0\n"))
+	osReleaseFile.Close()
+	defer os.Remove(osReleaseFile.Name())
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", allReleasesURI, httpmock.NewStringResponder(200, coreosReleaseHTTPResponse))
+
+	repo := newReleaseRepository(&http.Client{}, releaseFile.Name(), "/update/conf")
+	repo.osReleasePath = osReleaseFile.Name()
+
+	err := repo.GetInstalledVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "2135.4.0", repo.installedVersion.Version)
+	assert.Equal(t, "amd64-usr", repo.board)
+}
+
+func TestPollTimeoutOnlyBoundsCVEs(t *testing.T) {
+	releaseFile, _ := ioutil.TempFile("", "release")
+	releaseFile.Write([]byte("COREOS_RELEASE_VERSION=2135.4.0\nCOREOS_RELEASE_BOARD=amd64-usr\n"))
+	releaseFile.Close()
+	defer os.Remove(releaseFile.Name())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/releases.json":
+			w.Write([]byte(`{"2135.4.0": {"version": "2135.4.0", "release_notes": "Fix CVE-2019-0001"}}`))
+		case req.URL.Path == "/releases-stable.json":
+			// the CVEs of the installed release have used up the poll timeout by now
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte(`{"2135.5.0": {"version": "2135.5.0", "release_notes": "Fix CVE-2019-0002"}}`))
+		default:
+			// the CVE API never answers in time
+			<-req.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	// the CVE requests outlive the test, so they don't share the default transport which httpmock replaces
+	repo := newReleaseRepository(&http.Client{Transport: &http.Transport{}}, releaseFile.Name(), "/update/conf")
+	repo.channel = "stable"
+	repo.sources = releaseSources{CVE: server.URL + "/cve/%s", All: server.URL + "/releases.json", Stable: server.URL + "/releases-stable.json"}
+	repo.pollTimeout = 50 * time.Millisecond
+
+	ctx, cancel := repo.BeginPoll(context.Background())
+	defer cancel()
+	assert.NoError(t, repo.GetInstalledVersion(ctx))
+	assert.NoError(t, repo.GetLatestVersion(ctx), "the feed is retrieved after the CVE deadline")
+	assert.Equal(t, "2135.5.0", repo.latestVersion.Version)
+	assert.Equal(t, errCVEDeadline, repo.latestVersion.SecurityFixes[0].err)
+}
+
+func TestReleaseEnrichedDespitePermanentCVEErrors(t *testing.T) {
+	release := coreOSRelease{SecurityFixes: []cve{
+		{ID: "CVE-2019-0001", CVSS: 9.8},
+		{ID: "CVE-2019-0002", err: cvssError("No CVSS found!")},
+		{ID: "CVE-2019-0003", err: schemaError{uri: cveURI, err: errors.New("unexpected EOF")}},
+	}}
+	assert.True(t, release.enriched(), "retrieving the CVEs again won't change the documents")
+
+	release.SecurityFixes = append(release.SecurityFixes, cve{ID: "CVE-2019-0004", err: errCVEDeadline})
+	assert.False(t, release.enriched())
+
+	release.SecurityFixes[3].err = errors.New("GET http://cve.circl.lu/api/cve/CVE-2019-0004 giving up after 6 attempts")
+	assert.False(t, release.enriched())
+}
+
+func TestLatestVersionForBoard(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", stableReleasesURI,
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, `{
+				"2135.4.0": {"version": "2135.4.0", "release_notes": "", "architectures": ["amd64", "arm64"]},
+				"2135.5.0": {"version": "2135.5.0", "release_notes": "", "architectures": ["amd64"]}
+			}`), nil
+		},
+	)
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.channel = "stable"
+
+	repo.board = "amd64-usr"
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.Equal(t, "2135.5.0", repo.latestVersion.Version)
+
+	repo.board = "arm64-usr"
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.Equal(t, "2135.4.0", repo.latestVersion.Version, "2135.5.0 has not been published for arm64 yet")
+	assert.Equal(t, "stable arm64-usr", repo.State().channelDescription())
+
+	repo.board = "riscv-usr"
+	assert.EqualError(t, repo.GetLatestVersion(context.Background()), "No stable release has been published for the riscv-usr board")
+}
+
+func TestConditionalFeedRequests(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	etag := `"v1"`
+	feedRequests, cveRequests := 0, 0
+	httpmock.RegisterResponder("GET", stableReleasesURI,
+		func(req *http.Request) (*http.Response, error) {
+			feedRequests++
+			if req.Header.Get("If-None-Match") == etag {
+				return httpmock.NewStringResponse(http.StatusNotModified, ""), nil
+			}
+			resp := httpmock.NewStringResponse(200, `{"2135.5.0": {"version": "2135.5.0", "release_notes": "Fix CVE-2019-0001"}}`)
+			resp.Header.Set("ETag", etag)
+			return resp, nil
+		},
+	)
+	httpmock.RegisterResponder("GET", "http://cve.circl.lu/api/cve/CVE-2019-0001",
+		func(req *http.Request) (*http.Response, error) {
+			cveRequests++
+			return httpmock.NewStringResponse(200, `{"cvss": "9.8"}`), nil
+		},
+	)
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
+	repo.channel = "stable"
+
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.Equal(t, 2, feedRequests)
+	assert.Equal(t, 1, cveRequests, "the enrichment is skipped while the feed is unchanged")
+	assert.Equal(t, "2135.5.0", repo.latestVersion.Version)
+	assert.Equal(t, 9.8, *repo.latestVersion.MaxCVSS)
+
+	etag = `"v2"`
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.Equal(t, 2, cveRequests, "a changed feed is enriched again")
+
+	assert.Equal(t, feedValidators{ETag: `"v2"`}, repo.latestFeed.validatorsFor(stableReleasesURI, ""))
+	assert.Equal(t, feedValidators{}, repo.latestFeed.validatorsFor(betaReleasesURI, ""), "another feed is retrieved in full")
+}
+
+func TestReleasesForBoard(t *testing.T) {
+	releases := releaseFeed{
+		"1235.0.0": {Version: "1235.0.0"},
+		"2135.4.0": {Version: "2135.4.0", Architectures: []string{"arm64"}},
+	}
+
+	assert.Len(t, releasesForBoard(releases, "amd64-usr"), 1, "releases without architectures were only published for amd64")
+	assert.Contains(t, releasesForBoard(releases, "amd64-usr"), "1235.0.0")
+	assert.Contains(t, releasesForBoard(releases, "arm64-usr"), "2135.4.0")
+	assert.Len(t, releasesForBoard(releases, ""), 2)
+}
+
+func TestGetChannelGroupMapping(t *testing.T) {
+	updateFile, _ := ioutil.TempFile("", "update")
+	updateFile.Write([]byte("GROUP=coreUpdateChan1\n"))
+	updateFile.Close()
+	defer os.Remove(updateFile.Name())
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "https://releases.example.com/chan1.json", httpmock.NewStringResponder(200, coreosReleaseHTTPResponse))
+
+	repo := newReleaseRepository(&http.Client{}, "/release/conf", updateFile.Name())
+	assert.NoError(t, repo.GetChannel())
+	assert.Equal(t, "stable", repo.channel)
+	assert.False(t, repo.groupMapped)
+
+	_, err := checkGroupMapped(repo)()
+	assert.EqualError(t, err, "The update group coreUpdateChan1 is not in the group mapping, assuming the stable channel")
+
+	repo.groups = groupMappings{{Pattern: "coreUpdateChan[0-9]", Channel: "beta", Source: "https://releases.example.com/chan1.json"}}
+	assert.NoError(t, repo.GetChannel())
+	assert.Equal(t, "beta", repo.channel)
+	assert.Equal(t, "coreUpdateChan1", repo.State().Group)
+
+	output, err := checkGroupMapped(repo)()
+	assert.NoError(t, err)
+	assert.Equal(t, "Group coreUpdateChan1 follows the beta channel.", output)
+
+	assert.NoError(t, repo.GetLatestVersion(context.Background()))
+	assert.Equal(t, "2135.4.0", repo.latestVersion.Version)
+}
+
 func TestNoReleaseForVersion(t *testing.T) {
 	repo := newReleaseRepository(&http.Client{}, "/release/conf", "/update/conf")
 	assert.NoError(t, repo.err)
-	releases, err := GetJSON(repo.client, stableReleasesURI)
+	releases, _, err := getReleaseFeed(context.Background(), httpReleaseSource{client: repo.client}, stableReleasesURI, feedValidators{}, nil)
 	assert.NoError(t, repo.err)
 
-	os, err := repo.GetReleaseData("1.1.1", releases)
+	os, err := repo.GetReleaseData(context.Background(), "1.1.1", releases)
 	assert.EqualError(t, err, "Release not found")
 	assert.Nil(t, os)
 }
@@ -173,11 +363,11 @@ func TestReleaseRepository_UpdateError(t *testing.T) {
 }
 
 func TestGetLatestReleaseFromJSON(t *testing.T) {
-	releases := map[string]interface{}{
-		"2079.5.1": "",
-		"2079.6.1": "",
-		"522.4.0":  "",
-		"899.17.0": "",
+	releases := releaseFeed{
+		"2079.5.1": {},
+		"2079.6.1": {},
+		"522.4.0":  {},
+		"899.17.0": {},
 	}
 
 	expected := "2079.6.1"
@@ -185,6 +375,12 @@ func TestGetLatestReleaseFromJSON(t *testing.T) {
 	assert.Equal(t, expected, actual)
 }
 
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, -1, compareVersions("899.17.0", "2079.5.1"))
+	assert.Equal(t, 0, compareVersions("2079.5.1", "2079.5.1"))
+	assert.Equal(t, 1, compareVersions("2079.10.0", "2079.6.1"))
+}
+
 func TestLeftPad(t *testing.T) {
 	s := "test"
 	expected := "******test"
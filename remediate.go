@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	remediationCordon = "cordon"
+	remediationTaint  = "taint"
+
+	// remediatedAnnotation marks the nodes cordoned or tainted by the controller, so only those are restored.
+	remediatedAnnotation = nodeMetadataPrefix + "remediated"
+	// recommendedAnnotation records what the controller would have done to the node in dry-run mode.
+	recommendedAnnotation = nodeMetadataPrefix + "remediation-recommended"
+	remediationTaintKey   = nodeMetadataPrefix + "security-upgrade-overdue"
+)
+
+// remediationController cordons or taints the nodes which have passed the critical security fix deadline, so
+// workloads move onto patched nodes, without taking more than maxUnavailable nodes out of scheduling at once. The
+// nodes are restored once the checker reports them as upgraded.
+type remediationController struct {
+	client         kubernetesClient
+	action         string
+	taintEffect    string
+	maxUnavailable int
+	dryRun         bool
+	now            func() time.Time
+}
+
+func newRemediationController(client kubernetesClient, action string, taintEffect string, maxUnavailable int, dryRun bool) (*remediationController, error) {
+	if action != remediationCordon && action != remediationTaint {
+		return nil, fmt.Errorf("Unsupported remediation %q, must be %s or %s", action, remediationCordon, remediationTaint)
+	}
+
+	switch taintEffect {
+	case "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return nil, fmt.Errorf("Unsupported taint effect %q, must be NoSchedule, PreferNoSchedule or NoExecute", taintEffect)
+	}
+
+	return &remediationController{
+		client:         client,
+		action:         action,
+		taintEffect:    taintEffect,
+		maxUnavailable: maxUnavailable,
+		dryRun:         dryRun,
+		now:            time.Now,
+	}, nil
+}
+
+// Reconcile is registered with the aggregator to be called with the node states after every collection.
+func (c *remediationController) Reconcile(ctx context.Context, states map[string]nodeStatus) {
+	nodes, err := c.client.Nodes(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to list the Kubernetes nodes for remediation.")
+		return
+	}
+
+	unavailable := 0
+	for _, node := range nodes {
+		if c.unavailable(node) {
+			unavailable++
+		}
+	}
+
+	var overdue []kubeNode
+	deadlines := make(map[string]time.Time)
+	for _, node := range nodes {
+		name := node.Metadata.Name
+		state, collected := states[name]
+		deadline, isOverdue := c.criticalOverdue(state)
+
+		switch {
+		case isOverdue:
+			deadlines[name] = deadline
+			overdue = append(overdue, node)
+		case !collected || !upgraded(state):
+			// the checker may be restarting along with the node, or failing to poll, so leave the node as it is
+			// until the checker reports it as upgraded
+		case node.Metadata.Annotations[remediatedAnnotation] != "":
+			if c.restore(ctx, node) {
+				unavailable--
+			}
+		case node.Metadata.Annotations[recommendedAnnotation] != "":
+			if c.patch(ctx, node, annotationPatch(recommendedAnnotation, nil)) && c.dryRun {
+				unavailable--
+			}
+		}
+	}
+
+	// the nodes which have been overdue the longest are remediated first
+	sort.Slice(overdue, func(i, j int) bool {
+		a, b := overdue[i].Metadata.Name, overdue[j].Metadata.Name
+		if !deadlines[a].Equal(deadlines[b]) {
+			return deadlines[a].Before(deadlines[b])
+		}
+		return a < b
+	})
+
+	for _, node := range overdue {
+		if c.unavailable(node) {
+			continue
+		}
+
+		logger := log.WithField("node", node.Metadata.Name).WithField("remediation", c.action).WithField("deadline", deadlines[node.Metadata.Name])
+		if unavailable >= c.maxUnavailable {
+			logger.WithField("maxUnavailable", c.maxUnavailable).Warn("Node is past the critical security fix deadline, but the max unavailable budget is exhausted.")
+			continue
+		}
+
+		if c.remediate(ctx, node, logger) {
+			unavailable++
+		}
+	}
+}
+
+// criticalOverdue returns the deadline if the node has passed the deadline for a critical security fix, as scored by
+// the security policy.
+func (c *remediationController) criticalOverdue(state nodeStatus) (time.Time, bool) {
+	latest := state.LatestVersion
+	if state.LastSuccess == nil || state.InstalledVersion.Version == latest.Version {
+		return time.Time{}, false
+	}
+	if latest.MaxCVSS == nil || *latest.MaxCVSS < currentPolicy().CriticalCVSS {
+		return time.Time{}, false
+	}
+
+	deadline, ok := securityDeadline(latest)
+	return deadline, ok && c.now().After(deadline)
+}
+
+// upgraded is true once the checker has polled successfully and reports the latest version as installed.
+func upgraded(state nodeStatus) bool {
+	return state.LastSuccess != nil && state.InstalledVersion.Version != "" && state.InstalledVersion.Version == state.LatestVersion.Version
+}
+
+// unavailable reports whether the node is already out of scheduling, whether by the controller or anyone else. In
+// dry-run mode the recommendations count against the budget, as if they had been applied.
+func (c *remediationController) unavailable(node kubeNode) bool {
+	if node.Spec.Unschedulable || node.Metadata.Annotations[remediatedAnnotation] != "" {
+		return true
+	}
+	return c.dryRun && node.Metadata.Annotations[recommendedAnnotation] != ""
+}
+
+func (c *remediationController) remediate(ctx context.Context, node kubeNode, logger *log.Entry) bool {
+	if c.dryRun {
+		logger.Info("Dry run: would remediate the node, as it is past the critical security fix deadline.")
+		return c.patch(ctx, node, annotationPatch(recommendedAnnotation, c.action))
+	}
+
+	patch := annotationPatch(remediatedAnnotation, c.action)
+	if c.action == remediationCordon {
+		patch["spec"] = map[string]interface{}{"unschedulable": true}
+	} else {
+		taints := append(withoutTaint(node.Spec.Taints), kubeTaint{Key: remediationTaintKey, Value: "true", Effect: c.taintEffect})
+		patch["spec"] = map[string]interface{}{"taints": taints}
+	}
+
+	logger.Warn("Remediating the node, as it is past the critical security fix deadline.")
+	return c.patch(ctx, node, patch)
+}
+
+// restore reverts the remediation recorded on the node by the controller.
+func (c *remediationController) restore(ctx context.Context, node kubeNode) bool {
+	patch := annotationPatch(remediatedAnnotation, nil)
+	switch node.Metadata.Annotations[remediatedAnnotation] {
+	case remediationCordon:
+		patch["spec"] = map[string]interface{}{"unschedulable": nil}
+	case remediationTaint:
+		patch["spec"] = map[string]interface{}{"taints": withoutTaint(node.Spec.Taints)}
+	}
+
+	log.WithField("node", node.Metadata.Name).Info("Restoring the node, as it has been upgraded.")
+	return c.patch(ctx, node, patch)
+}
+
+// annotationPatch builds a merge patch setting the annotation, or removing it if value is nil.
+func annotationPatch(annotation string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{annotation: value},
+		},
+	}
+}
+
+// patch applies the merge patch to the node. The resource version makes the patch fail rather than overwrite the
+// taints if the node has changed since it was listed.
+func (c *remediationController) patch(ctx context.Context, node kubeNode, patch map[string]interface{}) bool {
+	metadata := patch["metadata"].(map[string]interface{})
+	metadata["resourceVersion"] = node.Metadata.ResourceVersion
+
+	if err := c.client.PatchNode(ctx, node.Metadata.Name, patch); err != nil {
+		log.WithError(err).WithField("node", node.Metadata.Name).Error("Failed to patch the node for remediation.")
+		return false
+	}
+	return true
+}
+
+// withoutTaint returns the taints other than the one added by the controller.
+func withoutTaint(taints []kubeTaint) []kubeTaint {
+	filtered := []kubeTaint{}
+	for _, taint := range taints {
+		if taint.Key != remediationTaintKey {
+			filtered = append(filtered, taint)
+		}
+	}
+	return filtered
+}
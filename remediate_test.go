@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKubeNode(name string, annotations map[string]string) kubeNode {
+	return kubeNode{Metadata: kubeObjectMeta{Name: name, ResourceVersion: "1", Annotations: annotations}}
+}
+
+func newTestRemediationStates(now time.Time) map[string]nodeStatus {
+	return map[string]nodeStatus{
+		"node-1": {Node: "node-1", releaseState: newTestState("2135.5.0", "2135.5.0", -1, now)},
+		"node-2": {Node: "node-2", releaseState: newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour*72))},
+		"node-3": {Node: "node-3", releaseState: newTestState("2135.4.0", "2135.5.0", 9.8, now.Add(-time.Hour*96))},
+		"node-4": {Node: "node-4", releaseState: newTestState("2135.4.0", "2135.5.0", 7.5, now.Add(-time.Hour*24*30))},
+	}
+}
+
+func TestRemediationControllerCordonsWithinBudget(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeKubernetesClient{nodes: []kubeNode{
+		newTestKubeNode("node-1", nil),
+		newTestKubeNode("node-2", nil),
+		newTestKubeNode("node-3", nil),
+		newTestKubeNode("node-4", nil),
+	}}
+
+	c, err := newRemediationController(fake, remediationCordon, "NoExecute", 1, false)
+	assert.NoError(t, err)
+	c.now = func() time.Time { return now }
+
+	c.Reconcile(context.Background(), newTestRemediationStates(now))
+	if assert.Len(t, fake.nodePatches, 1) {
+		// only one of the critical nodes fits in the budget, and the high severity node-4 is left alone
+		assert.Equal(t, map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations":     map[string]interface{}{remediatedAnnotation: "cordon"},
+				"resourceVersion": "1",
+			},
+			"spec": map[string]interface{}{"unschedulable": true},
+		}, fake.nodePatches[0])
+	}
+}
+
+func TestRemediationControllerBudgetExhausted(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	cordoned := newTestKubeNode("node-1", nil)
+	cordoned.Spec.Unschedulable = true
+	fake := &fakeKubernetesClient{nodes: []kubeNode{cordoned, newTestKubeNode("node-2", nil)}}
+
+	c, _ := newRemediationController(fake, remediationCordon, "NoExecute", 1, false)
+	c.now = func() time.Time { return now }
+
+	c.Reconcile(context.Background(), newTestRemediationStates(now))
+	assert.Empty(t, fake.nodePatches)
+}
+
+func TestRemediationControllerTaint(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	node := newTestKubeNode("node-2", nil)
+	node.Spec.Taints = []kubeTaint{{Key: "dedicated", Value: "ingress", Effect: "NoSchedule"}}
+	fake := &fakeKubernetesClient{nodes: []kubeNode{node}}
+
+	c, _ := newRemediationController(fake, remediationTaint, "NoSchedule", 1, false)
+	c.now = func() time.Time { return now }
+
+	c.Reconcile(context.Background(), newTestRemediationStates(now))
+	if assert.Len(t, fake.nodePatches, 1) {
+		spec := fake.nodePatches[0].(map[string]interface{})["spec"].(map[string]interface{})
+		assert.Equal(t, []kubeTaint{
+			{Key: "dedicated", Value: "ingress", Effect: "NoSchedule"},
+			{Key: remediationTaintKey, Value: "true", Effect: "NoSchedule"},
+		}, spec["taints"])
+	}
+}
+
+func TestRemediationControllerUsesPolicyCriticalCVSS(t *testing.T) {
+	defer setSecurityPolicy(defaultSecurityPolicy())
+	policy := defaultSecurityPolicy()
+	policy.CriticalCVSS = 8
+	setSecurityPolicy(policy)
+
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeKubernetesClient{nodes: []kubeNode{newTestKubeNode("node-5", nil)}}
+	c, _ := newRemediationController(fake, remediationCordon, "NoSchedule", 1, false)
+	c.now = func() time.Time { return now }
+
+	c.Reconcile(context.Background(), map[string]nodeStatus{
+		"node-5": {Node: "node-5", releaseState: newTestState("2135.4.0", "2135.5.0", 8.5, now.Add(-time.Hour*72))},
+	})
+	assert.Len(t, fake.nodePatches, 1, "8.5 is critical by the policy, though only high in the NVD bands")
+}
+
+func TestRemediationControllerRestoresUpgradedNodes(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	cordoned := newTestKubeNode("node-1", map[string]string{remediatedAnnotation: "cordon"})
+	cordoned.Spec.Unschedulable = true
+	tainted := newTestKubeNode("node-5", map[string]string{remediatedAnnotation: "taint"})
+	tainted.Spec.Taints = []kubeTaint{{Key: remediationTaintKey, Value: "true", Effect: "NoExecute"}}
+	manual := newTestKubeNode("node-6", nil)
+	manual.Spec.Unschedulable = true
+	fake := &fakeKubernetesClient{nodes: []kubeNode{cordoned, tainted, manual}}
+
+	states := newTestRemediationStates(now)
+	states["node-5"] = nodeStatus{Node: "node-5", releaseState: newTestState("2135.5.0", "2135.5.0", -1, now)}
+	states["node-6"] = nodeStatus{Node: "node-6", releaseState: newTestState("2135.5.0", "2135.5.0", -1, now)}
+
+	c, _ := newRemediationController(fake, remediationCordon, "NoExecute", 1, false)
+	c.now = func() time.Time { return now }
+
+	c.Reconcile(context.Background(), states)
+	if assert.Len(t, fake.nodePatches, 2) {
+		assert.Equal(t, map[string]interface{}{"unschedulable": nil}, fake.nodePatches[0].(map[string]interface{})["spec"])
+		assert.Equal(t, map[string]interface{}{"taints": []kubeTaint{}}, fake.nodePatches[1].(map[string]interface{})["spec"])
+	}
+}
+
+func TestRemediationControllerLeavesUncollectedNodes(t *testing.T) {
+	cordoned := newTestKubeNode("node-9", map[string]string{remediatedAnnotation: "cordon"})
+	cordoned.Spec.Unschedulable = true
+	fake := &fakeKubernetesClient{nodes: []kubeNode{cordoned}}
+
+	c, _ := newRemediationController(fake, remediationCordon, "NoExecute", 1, false)
+	c.Reconcile(context.Background(), map[string]nodeStatus{})
+	assert.Empty(t, fake.nodePatches)
+
+	// the checker restarted and has not polled yet, or its polls are failing
+	c.Reconcile(context.Background(), map[string]nodeStatus{"node-9": {Node: "node-9", releaseState: releaseState{Channel: "stable"}}})
+	assert.Empty(t, fake.nodePatches, "the node is only restored once the checker reports the upgrade")
+
+	behind := newTestState("2135.4.0", "2135.5.0", -1, time.Now())
+	behind.LastSuccess = nil
+	c.Reconcile(context.Background(), map[string]nodeStatus{"node-9": {Node: "node-9", releaseState: behind}})
+	assert.Empty(t, fake.nodePatches)
+}
+
+func TestRemediationControllerDryRun(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeKubernetesClient{nodes: []kubeNode{
+		newTestKubeNode("node-1", map[string]string{recommendedAnnotation: "cordon"}),
+		newTestKubeNode("node-2", nil),
+		newTestKubeNode("node-3", nil),
+	}}
+
+	c, _ := newRemediationController(fake, remediationCordon, "NoExecute", 2, true)
+	c.now = func() time.Time { return now }
+
+	c.Reconcile(context.Background(), newTestRemediationStates(now))
+	if assert.Len(t, fake.nodePatches, 3) {
+		assert.Equal(t, map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations":     map[string]interface{}{recommendedAnnotation: nil},
+				"resourceVersion": "1",
+			},
+		}, fake.nodePatches[0])
+		for _, patch := range fake.nodePatches[1:] {
+			assert.Equal(t, map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations":     map[string]interface{}{recommendedAnnotation: "cordon"},
+					"resourceVersion": "1",
+				},
+			}, patch)
+		}
+	}
+}
+
+func TestRemediationControllerListFailure(t *testing.T) {
+	fake := &fakeKubernetesClient{err: errors.New("nodes is forbidden")}
+	c, _ := newRemediationController(fake, remediationCordon, "NoExecute", 1, false)
+	c.Reconcile(context.Background(), newTestRemediationStates(time.Now()))
+	assert.Empty(t, fake.nodePatches)
+}
+
+func TestNewRemediationControllerValidation(t *testing.T) {
+	_, err := newRemediationController(&fakeKubernetesClient{}, "drain", "NoExecute", 1, false)
+	assert.Error(t, err)
+
+	_, err = newRemediationController(&fakeKubernetesClient{}, remediationTaint, "Evict", 1, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+var slackColours = map[eventType]string{
+	eventNewVersion:          "#439fe0",
+	eventSecurityFix:         "warning",
+	eventDeadlineApproaching: "warning",
+	eventDeadlinePassed:      "danger",
+	eventResolved:            "good",
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color,omitempty"`
+	Fields   []slackField `json:"fields,omitempty"`
+	Text     string       `json:"text,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackNotifier posts events to a Slack incoming webhook.
+type slackNotifier struct {
+	client     *retryablehttp.Client
+	webhookURL string
+}
+
+func newSlackNotifier(client *retryablehttp.Client, webhookURL string) *slackNotifier {
+	return &slackNotifier{client: client, webhookURL: webhookURL}
+}
+
+func (s *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, e event) error {
+	return PostJSON(ctx, s.client, s.webhookURL, newSlackMessage(e))
+}
+
+func newSlackMessage(e event) slackMessage {
+	fields := []slackField{
+		{Title: "Node", Value: e.Node, Short: true},
+		{Title: "Channel", Value: e.Channel, Short: true},
+		{Title: "Installed Version", Value: e.Installed, Short: true},
+		{Title: "Latest Version", Value: e.Latest, Short: true},
+	}
+	if e.Board != "" {
+		fields = append(fields, slackField{Title: "Board", Value: e.Board, Short: true})
+	}
+	if e.Deadline != nil {
+		fields = append(fields, slackField{Title: "Upgrade Deadline", Value: e.Deadline.UTC().Format(releaseDateFormat), Short: true})
+	}
+
+	attachment := slackAttachment{
+		Fallback: e.Summary(),
+		Color:    slackColours[e.Type],
+		Fields:   fields,
+	}
+
+	if e.Type != eventResolved && len(e.SecurityFixes) > 0 {
+		cves := make([]string, 0, len(e.SecurityFixes))
+		for _, fix := range e.SecurityFixes {
+			score := fmt.Sprintf("CVSS %.1f", fix.CVSS)
+			if fix.err != nil {
+				score = "CVSS unknown"
+			}
+			cves = append(cves, fmt.Sprintf("<%s|%s> (%s)", fmt.Sprintf(cveLinkURI, fix.ID), fix.ID, score))
+		}
+		attachment.Text = "*Security fixes:*\n" + strings.Join(cves, "\n")
+	}
+
+	return slackMessage{
+		Text:        e.Summary(),
+		Attachments: []slackAttachment{attachment},
+	}
+}
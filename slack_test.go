@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	var received slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	deadline := time.Date(2019, 6, 27, 20, 35, 0, 0, time.UTC)
+	s := newSlackNotifier(newRetryableClient(&http.Client{}), server.URL)
+	err := s.Notify(context.Background(), event{
+		Type:          eventDeadlinePassed,
+		Node:          "node-1",
+		Channel:       "stable",
+		Installed:     "2135.4.0",
+		Latest:        "2135.5.0",
+		MaxCVSS:       9.8,
+		Deadline:      &deadline,
+		SecurityFixes: []cve{{ID: "CVE-2019-0002", CVSS: 9.8}, {ID: "CVE-2019-0003", err: errCVEDeadline}},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "node-1 has passed the deadline of 2019-06-27 20:35 UTC to upgrade to CoreOS 2135.5.0!", received.Text)
+	assert.Len(t, received.Attachments, 1)
+	assert.Equal(t, "danger", received.Attachments[0].Color)
+	assert.Len(t, received.Attachments[0].Fields, 5)
+	assert.Equal(t, "*Security fixes:*\n<https://cve.circl.lu/cve/CVE-2019-0002|CVE-2019-0002> (CVSS 9.8)\n"+
+		"<https://cve.circl.lu/cve/CVE-2019-0003|CVE-2019-0003> (CVSS unknown)", received.Attachments[0].Text)
+}
+
+func TestSlackNotifierFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	s := newSlackNotifier(newRetryableClient(&http.Client{}), server.URL)
+	err := s.Notify(context.Background(), event{Type: eventNewVersion})
+	assert.Error(t, err)
+}
@@ -1,52 +1,146 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"strings"
+	"log"
+	"net/http"
+	"time"
 
 	retryablehttp "github.com/hashicorp/go-retryablehttp"
 )
 
-func getValueFromFile(key, path string) (val string, err error) {
-	content, err := ioutil.ReadFile(path)
-	if err != nil {
-		return "", err
+func newRetryableClient(client *http.Client) *retryablehttp.Client {
+	return &retryablehttp.Client{
+		HTTPClient:   client,
+		Logger:       log.New(ioutil.Discard, "", log.LstdFlags),
+		RetryWaitMin: 100 * time.Millisecond,
+		RetryWaitMax: 2 * time.Second,
+		RetryMax:     5,
+		CheckRetry:   checkRetry,
+		Backoff:      retryablehttp.DefaultBackoff,
 	}
+}
 
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, key) {
-			v := strings.TrimPrefix(line, key)
-			return v, nil
-		}
+// checkRetry retries like the default policy, except once the context of the request is done.
+func checkRetry(resp *http.Response, err error) (bool, error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, err
 	}
+	return retryablehttp.DefaultRetryPolicy(resp, err)
+}
+
+// Open performs a GET request using the given client, and returns the body of the response for the caller to close.
+// Any non-2xx response is an error.
+func Open(ctx context.Context, client *retryablehttp.Client, uri string) (io.ReadCloser, error) {
+	body, _, err := OpenIfModified(ctx, client, uri, feedValidators{})
+	return body, err
+}
+
+// errNotModified is returned by OpenIfModified when the document has not changed.
+var errNotModified = errors.New("Not modified")
 
-	return "", fmt.Errorf("No %s in %s", val, path)
+// feedValidators are the ETag and Last-Modified of a retrieved document, sent back as a conditional request so the
+// document is only downloaded again once it has changed.
+type feedValidators struct {
+	ETag         string
+	LastModified string
 }
 
-// GetJSON performs a GET request using the given client, and parses the response to a map[string]interface{}
-func GetJSON(client *retryablehttp.Client, uri string) (map[string]interface{}, error) {
+// OpenIfModified performs a conditional GET request with the validators of the previous response, returning
+// errNotModified if the server answers 304 Not Modified, otherwise the body of the document and its new validators.
+func OpenIfModified(ctx context.Context, client *retryablehttp.Client, uri string, validators feedValidators) (io.ReadCloser, feedValidators, error) {
 	req, err := retryablehttp.NewRequest("GET", uri, nil)
 	if err != nil {
-		return nil, err
+		return nil, validators, err
+	}
+	req.Request = req.Request.WithContext(ctx)
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, validators, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, validators, errNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, validators, fmt.Errorf("Unexpected status code %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	return resp.Body, feedValidators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// PostJSON performs a POST request of the given payload as JSON using the given client, and fails on any non-2xx response
+func PostJSON(ctx context.Context, client *retryablehttp.Client, uri string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	return Send(ctx, client, "POST", uri, body, header)
+}
+
+// Send performs a request with the given body and headers using the given client, and fails on any non-2xx response
+func Send(ctx context.Context, client *retryablehttp.Client, method string, uri string, body []byte, header http.Header) error {
+	req, err := retryablehttp.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Request = req.Request.WithContext(ctx)
+	for key, values := range header {
+		req.Header[key] = values
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status code %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	return nil
+}
 
-	dec := json.NewDecoder(resp.Body)
-	data := make(map[string]interface{})
+// duration wraps time.Duration so it can be used as a mow.cli option value
+type duration time.Duration
 
-	err = dec.Decode(&data)
+func (d *duration) Set(v string) error {
+	parsed, err := time.ParseDuration(v)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d *duration) String() string {
+	return time.Duration(*d).String()
+}
 
-	return data, nil
+// UnmarshalYAML parses durations in config files, e.g. 30m
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v string
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return d.Set(v)
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+const defaultSignatureHeader = "X-Signature-256"
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// webhookConfig describes an outbound webhook. The payload is rendered from a Go template with the event as its
+// data; if no template is configured the event is sent as JSON.
+type webhookConfig struct {
+	Name            string            `json:"name"`
+	URL             string            `json:"url"`
+	Method          string            `json:"method,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Template        string            `json:"template,omitempty"`
+	Secret          string            `json:"secret,omitempty"`
+	SignatureHeader string            `json:"signatureHeader,omitempty"`
+	Events          []eventType       `json:"events,omitempty"`
+}
+
+type webhookPayload struct {
+	event
+	Summary string `json:"summary"`
+}
+
+// webhookNotifier sends events to an arbitrary HTTP endpoint. When a secret is configured, the payload is signed
+// with HMAC-SHA256 and the hex encoded signature is sent in the signature header as "sha256=<signature>".
+type webhookNotifier struct {
+	client   *retryablehttp.Client
+	config   webhookConfig
+	template *template.Template
+}
+
+func loadWebhookConfigs(path string) ([]webhookConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []webhookConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+func newWebhookNotifier(client *retryablehttp.Client, config webhookConfig) (*webhookNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("No URL configured for webhook " + config.Name)
+	}
+	if config.Name == "" {
+		config.Name = config.URL
+	}
+	if config.Method == "" {
+		config.Method = "POST"
+	}
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = defaultSignatureHeader
+	}
+
+	w := &webhookNotifier{client: client, config: config}
+	if config.Template != "" {
+		tmpl, err := template.New(config.Name).Funcs(webhookFuncs).Parse(config.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.template = tmpl
+	}
+	return w, nil
+}
+
+func (w *webhookNotifier) Name() string {
+	return "webhook " + w.config.Name
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, e event) error {
+	if !w.Subscribed(e.Type) {
+		return nil
+	}
+
+	body, err := w.render(e)
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	for key, value := range w.config.Headers {
+		header.Set(key, value)
+	}
+	if w.config.Secret != "" {
+		header.Set(w.config.SignatureHeader, "sha256="+sign(body, w.config.Secret))
+	}
+
+	return Send(ctx, w.client, w.config.Method, w.config.URL, body, header)
+}
+
+// Subscribed is true for the events listed in the config, or for every event if none are listed.
+func (w *webhookNotifier) Subscribed(t eventType) bool {
+	if len(w.config.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range w.config.Events {
+		if subscribed == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *webhookNotifier) render(e event) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(webhookPayload{event: e, Summary: e.Summary()})
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifierTemplate(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	webhook, err := newWebhookNotifier(newRetryableClient(&http.Client{}), webhookConfig{
+		URL:      server.URL,
+		Method:   "PUT",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Template: `{"text": {{ .Summary | json }}, "cves": [{{ range $i, $fix := .SecurityFixes }}{{ if $i }},{{ end }}"{{ $fix.ID }}"{{ end }}]}`,
+		Secret:   "s3cr3t",
+	})
+	assert.NoError(t, err)
+
+	err = webhook.Notify(context.Background(), event{
+		Type:          eventSecurityFix,
+		Node:          "node-1",
+		Latest:        "2135.5.0",
+		MaxCVSS:       7.5,
+		SecurityFixes: []cve{{ID: "CVE-2019-0001", CVSS: 7.5}, {ID: "CVE-2019-0002", CVSS: 5}},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"text": "New CoreOS version 2135.5.0 available on node-1 contains 2 security fix(es), max CVSS 7.5.", "cves": ["CVE-2019-0001","CVE-2019-0002"]}`, string(body))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "sha256="+sign(body, "s3cr3t"), header.Get(defaultSignatureHeader))
+}
+
+func TestWebhookNotifierDefaultPayload(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Empty(t, r.Header.Get(defaultSignatureHeader))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+	}))
+	defer server.Close()
+
+	webhook, err := newWebhookNotifier(newRetryableClient(&http.Client{}), webhookConfig{URL: server.URL})
+	assert.NoError(t, err)
+
+	err = webhook.Notify(context.Background(), event{Type: eventResolved, Node: "node-1", Installed: "2135.5.0", Latest: "2135.5.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "resolved", payload["type"])
+	assert.Equal(t, "node-1 has been upgraded to CoreOS 2135.5.0.", payload["summary"])
+}
+
+func TestWebhookNotifierEventFilterAndRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	webhook, err := newWebhookNotifier(newRetryableClient(&http.Client{}), webhookConfig{
+		URL:    server.URL,
+		Events: []eventType{eventDeadlinePassed},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, webhook.Notify(context.Background(), event{Type: eventNewVersion}))
+	assert.Equal(t, 0, attempts)
+
+	deadline := time.Now()
+	assert.NoError(t, webhook.Notify(context.Background(), event{Type: eventDeadlinePassed, Deadline: &deadline}))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestLoadWebhookConfigs(t *testing.T) {
+	f, _ := ioutil.TempFile("", "webhooks")
+	f.Write([]byte(`[{"name": "ops", "url": "http://localhost/hook", "events": ["deadline-passed"]}]`))
+	f.Close()
+	defer os.Remove(f.Name())
+
+	configs, err := loadWebhookConfigs(f.Name())
+	assert.NoError(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "ops", configs[0].Name)
+	assert.Equal(t, []eventType{eventDeadlinePassed}, configs[0].Events)
+
+	_, err = newWebhookNotifier(newRetryableClient(&http.Client{}), webhookConfig{Name: "broken"})
+	assert.EqualError(t, err, "No URL configured for webhook broken")
+}